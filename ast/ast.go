@@ -3,6 +3,8 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"math/big"
+	"sort"
 	"strings"
 
 	"github.com/ShivankSharma070/go-compiler/token"
@@ -11,6 +13,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // Position of first character belonging to the node
+	End() token.Position // Position immediately after the node
 }
 
 type Statement interface {
@@ -35,6 +39,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var buf bytes.Buffer
 	for _, stmt := range p.Statements {
@@ -57,6 +75,17 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	if ls.Name != nil {
+		return ls.Name.End()
+	}
+	return ls.Token.End
+}
+
 func (ls *LetStatement) String() string {
 	var buf bytes.Buffer
 
@@ -88,6 +117,9 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+func (i *Identifier) End() token.Position { return i.Token.End }
+
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -101,6 +133,8 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
 
 // Return Statments
 type ReturnStatement struct {
@@ -113,6 +147,14 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
+
 func (rs *ReturnStatement) String() string {
 	var buf bytes.Buffer
 	buf.WriteString(rs.TokenLiteral() + " ")
@@ -122,6 +164,97 @@ func (rs *ReturnStatement) String() string {
 	return buf.String()
 }
 
+// while (condition) { body }
+type WhileStatement struct {
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+
+func (ws *WhileStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("while ")
+	buf.WriteString(ws.Condition.String())
+	buf.WriteString(" ")
+	buf.WriteString(ws.Body.String())
+	return buf.String()
+}
+
+// for (value in iterable) { body } or for (key, value in iterable) { body }. Key is nil in the
+// first form.
+type ForStatement struct {
+	Token    token.Token
+	Key      *Identifier
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+
+// Names of the loop variables, in the order they are written
+func (fs *ForStatement) Variables() []*Identifier {
+	if fs.Key == nil {
+		return []*Identifier{fs.Value}
+	}
+	return []*Identifier{fs.Key, fs.Value}
+}
+
+func (fs *ForStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("for (")
+	if fs.Key != nil {
+		buf.WriteString(fs.Key.String())
+		buf.WriteString(", ")
+	}
+	buf.WriteString(fs.Value.String())
+	buf.WriteString(" in ")
+	buf.WriteString(fs.Iterable.String())
+	buf.WriteString(") ")
+	buf.WriteString(fs.Body.String())
+	return buf.String()
+}
+
+// break; leaves the innermost loop
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+// continue; starts the next iteration of the innermost loop
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+
 // Expression statement
 // 5+10, (5*10)+5, foo(a,b) etc
 // We are treating expression as statements because, we want to allow one line containing only expression as a statement
@@ -135,6 +268,14 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Pos() token.Position { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
+
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -151,6 +292,31 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+// Integer literals which do not fit in an int64
+type BigIntegerLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (bl *BigIntegerLiteral) expressionNode()      {}
+func (bl *BigIntegerLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BigIntegerLiteral) String() string       { return bl.Token.Literal }
+func (bl *BigIntegerLiteral) Pos() token.Position  { return bl.Token.Pos }
+func (bl *BigIntegerLiteral) End() token.Position  { return bl.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token
@@ -160,6 +326,13 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
 	buf := bytes.Buffer{}
 	buf.WriteString("(")
@@ -179,6 +352,18 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(")
@@ -189,6 +374,62 @@ func (ie *InfixExpression) String() string {
 	return buf.String()
 }
 
+// Assigns a new value to an existing variable: x = x + 1
+type AssignExpression struct {
+	Token token.Token // The = token
+	Name  *Identifier
+	Value Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Name.Pos() }
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+func (ae *AssignExpression) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	buf.WriteString(ae.Name.String())
+	buf.WriteString(" = ")
+	if ae.Value != nil {
+		buf.WriteString(ae.Value.String())
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+// a[i] = v and h[k] = v
+type IndexAssignExpression struct {
+	Token  token.Token // The = token
+	Target *IndexExpression
+	Value  Expression
+}
+
+func (ia *IndexAssignExpression) expressionNode()      {}
+func (ia *IndexAssignExpression) TokenLiteral() string { return ia.Token.Literal }
+func (ia *IndexAssignExpression) Pos() token.Position  { return ia.Target.Pos() }
+func (ia *IndexAssignExpression) End() token.Position {
+	if ia.Value != nil {
+		return ia.Value.End()
+	}
+	return ia.Token.End
+}
+func (ia *IndexAssignExpression) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	buf.WriteString(ia.Target.String())
+	buf.WriteString(" = ")
+	if ia.Value != nil {
+		buf.WriteString(ia.Value.String())
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
 type BoolExpression struct {
 	Token token.Token
 	Value bool
@@ -197,6 +438,8 @@ type BoolExpression struct {
 func (be *BoolExpression) expressionNode()      {}
 func (be *BoolExpression) TokenLiteral() string { return be.Token.Literal }
 func (be *BoolExpression) String() string       { return be.Token.Literal }
+func (be *BoolExpression) Pos() token.Position  { return be.Token.Pos }
+func (be *BoolExpression) End() token.Position  { return be.Token.End }
 
 type IfElseExpression struct {
 	Token       token.Token
@@ -207,6 +450,16 @@ type IfElseExpression struct {
 
 func (ie *IfElseExpression) expressionNode()      {}
 func (ie *IfElseExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfElseExpression) Pos() token.Position { return ie.Token.Pos }
+func (ie *IfElseExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
 func (ie *IfElseExpression) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("if ")
@@ -223,12 +476,23 @@ func (ie *IfElseExpression) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // The { token
 	Statements []Statement
+	RBrace     token.Token
 }
 
 func (be *BlockStatement) statementNode()       {}
 func (be *BlockStatement) TokenLiteral() string { return be.Token.Literal }
+func (be *BlockStatement) Pos() token.Position { return be.Token.Pos }
+func (be *BlockStatement) End() token.Position {
+	if be.RBrace.End.IsValid() {
+		return be.RBrace.End
+	}
+	if len(be.Statements) > 0 {
+		return be.Statements[len(be.Statements)-1].End()
+	}
+	return be.Token.End
+}
 func (be *BlockStatement) String() string {
 	var buf bytes.Buffer
 	for _, st := range be.Statements {
@@ -247,6 +511,13 @@ type FunctionExpression struct {
 
 func (fe *FunctionExpression) expressionNode()      {}
 func (fe *FunctionExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *FunctionExpression) Pos() token.Position { return fe.Token.Pos }
+func (fe *FunctionExpression) End() token.Position {
+	if fe.Body != nil {
+		return fe.Body.End()
+	}
+	return fe.Token.End
+}
 func (fe *FunctionExpression) String() string {
 	var buf bytes.Buffer
 
@@ -266,13 +537,26 @@ func (fe *FunctionExpression) String() string {
 }
 
 type CallExpression struct {
-	Token    token.Token
+	Token    token.Token // The ( token
 	Function Expression
 	Argument []Expression
+	RParen   token.Token
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+func (ce *CallExpression) End() token.Position {
+	if ce.RParen.End.IsValid() {
+		return ce.RParen.End
+	}
+	return ce.Token.End
+}
 func (ce *CallExpression) String() string {
 	var buf bytes.Buffer
 	arguments := []string{}
@@ -287,12 +571,20 @@ func (ce *CallExpression) String() string {
 }
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // The [ token
 	Elements []Expression
+	RBracket token.Token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position {
+	if al.RBracket.End.IsValid() {
+		return al.RBracket.End
+	}
+	return al.Token.End
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -308,13 +600,26 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // The [ token
+	Left     Expression
+	Index    Expression
+	RBracket token.Token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *IndexExpression) End() token.Position {
+	if ie.RBracket.End.IsValid() {
+		return ie.RBracket.End
+	}
+	return ie.Token.End
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -326,18 +631,84 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// a[low:high], either bound can be left out
+type SliceExpression struct {
+	Token    token.Token // The [ token
+	Left     Expression
+	Low      Expression // nil if left out
+	High     Expression // nil if left out
+	RBracket token.Token
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() token.Position {
+	if se.Left != nil {
+		return se.Left.Pos()
+	}
+	return se.Token.Pos
+}
+func (se *SliceExpression) End() token.Position {
+	if se.RBracket.End.IsValid() {
+		return se.RBracket.End
+	}
+	return se.Token.End
+}
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token // The { token
+	Pairs  map[Expression]Expression
+	RBrace token.Token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
+// SortedKeys returns the keys ordered by their source text, keys written the same way by their
+// position. Pairs are evaluated, and so end up in the hash, in this order, which does not change
+// from run to run like map order does.
+func (hl *HashLiteral) SortedKeys() []Expression {
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for k := range hl.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].String(), keys[j].String()
+		if a != b {
+			return a < b
+		}
+		return keys[i].Pos().Offset < keys[j].Pos().Offset
+	})
+	return keys
+}
+func (hl *HashLiteral) End() token.Position {
+	if hl.RBrace.End.IsValid() {
+		return hl.RBrace.End
+	}
+	return hl.Token.End
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.SortedKeys() {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -29,3 +29,29 @@ func TestString(t *testing.T) {
 	}
 
 }
+
+func TestHashLiteralSortedKeys(t *testing.T) {
+	key := func(literal string, offset int) Expression {
+		return &StringLiteral{Token: token.Token{Type: token.STRING, Literal: literal, Pos: token.Position{Offset: offset}}, Value: literal}
+	}
+	value := func(literal string) Expression {
+		return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal}}
+	}
+
+	// {"x": 1, "b": 2, "x": 3}
+	hash := &HashLiteral{Pairs: map[Expression]Expression{
+		key("x", 1):  value("1"),
+		key("b", 9):  value("2"),
+		key("x", 17): value("3"),
+	}}
+
+	for i := 0; i < 10; i++ {
+		keys := hash.SortedKeys()
+		if keys[0].String() != "b" || keys[1].Pos().Offset != 1 || keys[2].Pos().Offset != 17 {
+			t.Fatalf("keys are not ordered by text and position, got %v", keys)
+		}
+	}
+	if hash.String() != "{b:2, x:1, x:3}" {
+		t.Errorf("wrong String. got=%q", hash.String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ShivankSharma070/go-compiler/compiler"
+)
+
+// buildCommand implements `build [-o output] [-stats] [-O level] <file>`, compiling a source
+// file into a bytecode file which the run command can execute directly.
+func buildCommand(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "output file, defaults to the input file with a .mbc extension")
+	stats := flags.Bool("stats", false, "print constant pool statistics to stderr")
+	optimization := optimizationFlag(flags)
+	files, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if !validOptimizationLevel(*optimization) {
+		return exitUsage
+	}
+
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "build expects exactly one file, got %d\n\n%s", len(files), usage)
+		return exitUsage
+	}
+
+	input := files[0]
+	if *output == "" {
+		if input == "-" {
+			fmt.Fprintln(os.Stderr, "build needs -o when reading from stdin")
+			return exitUsage
+		}
+		*output = strings.TrimSuffix(input, filepath.Ext(input)) + ".mbc"
+	}
+
+	filename, data, err := readInput(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	program, ok := parseSource(filename, string(data))
+	if !ok {
+		return exitError
+	}
+	comp, ok := compileProgram(filename, program, *optimization)
+	if !ok {
+		return exitError
+	}
+	bytecode := comp.Bytecode()
+
+	if *stats {
+		s := comp.ConstantPoolStats()
+		fmt.Fprintf(os.Stderr, "constant pool: %d slots (%d integers, %d floats, %d strings, %d functions), %d literals reused a slot\n",
+			s.Size, s.Integers, s.Floats, s.Strings, s.Functions, s.Reused)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	err = compiler.WriteBytecode(file, bytecode)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *output, err)
+		return exitError
+	}
+
+	return exitOK
+}
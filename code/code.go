@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 )
 
 const (
@@ -37,6 +38,32 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpTailCall // Like OpCall, but the result is returned right away so the frame can be reused
+	OpWide     // Prefix which doubles the operand widths of the next instruction, see MakeWide
+
+	// Variables which are captured by closures and assigned to live in cells, see compiler/cells.go
+	OpNewCell      // Replaces the value on top of the stack by a cell holding it
+	OpDeref        // Replaces the cell on top of the stack by the value it holds
+	OpSetLocalCell // Stores a value into the cell held by a local
+	OpSetFree      // Stores a value into the cell held by a free variable
+
+	// for loops keep an iterator on the stack while they run
+	OpIter     // Replaces the array or hash on top of the stack by an iterator over it
+	OpIterNext // Pushes the loop variables of the next iteration, or jumps once the iterator is done
+
+	OpSetGlobalCell // Stores a value into the cell held by a global, see compiler/loops.go
+
+	OpGreaterEqual // Like OpGreaterThan, <= is compiled by swapping the operands
+	OpMod
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpBitNot
+
+	OpSlice    // Pops the high bound, the low bound and the array or string, null for a bound which is left out
+	OpSetIndex // Pops the value, the index and the array or hash, stores the value and pushes it back
 )
 
 type Instructions []byte
@@ -45,14 +72,19 @@ func (ins Instructions) String() string {
 	var out bytes.Buffer
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(Opcode(ins[i]))
+		op, operands, width, err := ReadInstruction(ins, i)
 		if err != nil {
 			fmt.Fprintf(&out, "Error: %s\n", err)
+			break
 		}
 
-		operands, read := ReadOperands(def, ins[i+1:])
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
-		i += 1 + read
+		def, _ := Lookup(op)
+		prefix := ""
+		if Opcode(ins[i]) == OpWide {
+			prefix = "OpWide "
+		}
+		fmt.Fprintf(&out, "%04d %s%s\n", i, prefix, ins.fmtInstruction(def, operands))
+		i += width
 	}
 
 	return out.String()
@@ -115,6 +147,25 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:       {"OpClosure", []int{2, 1}},
 	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure : {"OpCurrentClosure", []int{}},
+	OpTailCall:       {"OpTailCall", []int{1}},
+	OpWide:           {"OpWide", []int{}},
+	OpNewCell:        {"OpNewCell", []int{}},
+	OpDeref:          {"OpDeref", []int{}},
+	OpSetLocalCell:   {"OpSetLocalCell", []int{1}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpIter:           {"OpIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2, 1}}, // Jump target, number of loop variables
+	OpSetGlobalCell:  {"OpSetGlobalCell", []int{2}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpShiftLeft:      {"OpShiftLeft", []int{}},
+	OpShiftRight:     {"OpShiftRight", []int{}},
+	OpBitNot:         {"OpBitNot", []int{}},
+	OpSlice:          {"OpSlice", []int{}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
 }
 
 func Lookup(op Opcode) (*Definition, error) {
@@ -126,28 +177,51 @@ func Lookup(op Opcode) (*Definition, error) {
 	return def, nil
 }
 
+// Make encodes an instruction. If an operand does not fit in its normal width the instruction
+// is encoded with the OpWide prefix instead, operands which do not fit either way are truncated,
+// use CheckOperands to find out beforehand.
 func Make(op Opcode, operands ...int) Instructions {
 	def, ok := definitions[op]
 	if !ok {
 		return []byte{}
 	}
+	if NeedsWide(op, operands...) {
+		return MakeWide(op, operands...)
+	}
+
+	return makeInstruction([]byte{byte(op)}, def.OperandWidths, operands)
+}
 
-	instructionLen := 1 // 1 byte of opcode is already considered here
-	for _, w := range def.OperandWidths {
+// MakeWide encodes an instruction with the OpWide prefix, whether or not its operands need it.
+// Opcodes without operands can not be prefixed, they give empty instructions.
+func MakeWide(op Opcode, operands ...int) Instructions {
+	def, ok := definitions[op]
+	if !ok || len(def.OperandWidths) == 0 || op == OpWide {
+		return []byte{}
+	}
+
+	return makeInstruction([]byte{byte(OpWide), byte(op)}, WideOperandWidths(def), operands)
+}
+
+func makeInstruction(prefix []byte, widths []int, operands []int) Instructions {
+	instructionLen := len(prefix)
+	for _, w := range widths {
 		instructionLen += w
 	}
 
 	instruction := make([]byte, instructionLen)
-	instruction[0] = byte(op)
+	copy(instruction, prefix)
 
-	offset := 1
+	offset := len(prefix)
 	for i, o := range operands {
-		width := def.OperandWidths[i]
+		width := widths[i]
 		switch width {
 		case 1:
 			instruction[offset] = byte(o)
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		}
 		offset += width
 	}
@@ -155,16 +229,73 @@ func Make(op Opcode, operands ...int) Instructions {
 	return instruction
 }
 
+// WideOperandWidths returns the operand widths of def after an OpWide prefix, twice the normal ones
+func WideOperandWidths(def *Definition) []int {
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = 2 * w
+	}
+	return widths
+}
+
+// NeedsWide reports whether some operand of op does not fit in its normal width
+func NeedsWide(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+	for i, o := range operands {
+		if i < len(def.OperandWidths) && !fits(o, def.OperandWidths[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOperands reports an error if op can not encode operands, even with the OpWide prefix
+func CheckOperands(op Opcode, operands ...int) error {
+	def, err := Lookup(op)
+	if err != nil {
+		return err
+	}
+	for i, o := range operands {
+		if i >= len(def.OperandWidths) {
+			return fmt.Errorf("%s takes %d operands, got %d", def.Name, len(def.OperandWidths), len(operands))
+		}
+		width := 2 * def.OperandWidths[i]
+		if !fits(o, width) {
+			return fmt.Errorf("%s operand %d does not fit in %d bytes", def.Name, o, width)
+		}
+	}
+	return nil
+}
+
+func fits(operand, width int) bool {
+	return operand >= 0 && uint64(operand) < 1<<(8*width)
+}
+
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
-	operands := make([]int, len(def.OperandWidths))
+	return readOperands(def.OperandWidths, ins)
+}
+
+// ReadWideOperands reads the operands of an instruction prefixed by OpWide, ins starts after
+// the opcode which follows the prefix
+func ReadWideOperands(def *Definition, ins Instructions) ([]int, int) {
+	return readOperands(WideOperandWidths(def), ins)
+}
+
+func readOperands(widths []int, ins Instructions) ([]int, int) {
+	operands := make([]int, len(widths))
 	offset := 0
 
-	for i, width := range def.OperandWidths {
+	for i, width := range widths {
 		switch width {
 		case 1:
 			operands[i] = int(ReadUint8(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		}
 
 		offset += width
@@ -173,8 +304,26 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
 func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
+
+// DefinitionsHash fingerprints the instruction set, i.e. every opcode along with its name and
+// operand widths. Bytecode compiled against a different instruction set has a different hash.
+func DefinitionsHash() uint64 {
+	h := fnv.New64a()
+	for op := 0; op < 256; op++ {
+		def, ok := definitions[Opcode(op)]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(h, "%d %s %v;", op, def.Name, def.OperandWidths)
+	}
+	return h.Sum64()
+}
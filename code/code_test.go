@@ -2,6 +2,8 @@ package code
 
 import (
 	"testing"
+
+	"github.com/ShivankSharma070/go-compiler/token"
 )
 
 func TestMake(t *testing.T) {
@@ -14,6 +16,9 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534,255}, []byte{byte(OpClosure), 255,254,255}},
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{1, 300}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 44}},
 	}
 	for _, tt := range tests {
 		instructions := Make(tt.op, tt.operands...)
@@ -38,6 +43,7 @@ func TestInstructionString(t *testing.T) {
 		Make(OpAdd),
 		Make(OpGetLocal, 5),
 		Make(OpClosure, 65535, 255),
+		Make(OpGetLocal, 256),
 	}
 
 	expected := `0000 OpConstant 1
@@ -46,6 +52,7 @@ func TestInstructionString(t *testing.T) {
 0009 OpAdd
 0010 OpGetLocal 5
 0012 OpClosure 65535 255
+0016 OpWide OpGetLocal 256
 `
 
 	concatted := Instructions{}
@@ -89,3 +96,168 @@ func TestReadOperands(t *testing.T) {
 
 	}
 }
+
+func TestWideInstructions(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		width    int
+	}{
+		{OpConstant, []int{1}, 6},
+		{OpConstant, []int{1<<32 - 1}, 6},
+		{OpJump, []int{70000}, 6},
+		{OpGetLocal, []int{65535}, 4},
+		{OpClosure, []int{65536, 256}, 8},
+	}
+
+	for _, tt := range tests {
+		ins := MakeWide(tt.op, tt.operands...)
+		op, operands, width, err := ReadInstruction(ins, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if op != tt.op {
+			t.Errorf("wrong opcode. want=%d, got=%d", tt.op, op)
+		}
+		if width != tt.width || len(ins) != tt.width {
+			t.Errorf("wrong width. want=%d, got=%d (encoded %d)", tt.width, width, len(ins))
+		}
+		for i, want := range tt.operands {
+			if operands[i] != want {
+				t.Errorf("operand wrong, want=%d, got=%d", want, operands[i])
+			}
+		}
+	}
+}
+
+func TestCheckOperands(t *testing.T) {
+	tests := []struct {
+		op          Opcode
+		operands    []int
+		expectedErr string
+	}{
+		{OpGetLocal, []int{65535}, ""},
+		{OpConstant, []int{1<<32 - 1}, ""},
+		{OpGetLocal, []int{65536}, "OpGetLocal operand 65536 does not fit in 2 bytes"},
+		{OpClosure, []int{0, 70000}, "OpClosure operand 70000 does not fit in 2 bytes"},
+		{OpCall, []int{-1}, "OpCall operand -1 does not fit in 2 bytes"},
+	}
+
+	for _, tt := range tests {
+		err := CheckOperands(tt.op, tt.operands...)
+		if tt.expectedErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expectedErr {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expectedErr, err)
+		}
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	pos := func(line int) token.Position { return token.Position{Line: line, Column: 1} }
+
+	var lines LineTable
+	lines = lines.Add(0, pos(1))
+	lines = lines.Add(3, pos(1))
+	lines = lines.Add(4, pos(2))
+	lines = lines.Add(6, token.Position{})
+	lines = lines.Add(9, pos(4))
+
+	if len(lines) != 3 {
+		t.Fatalf("wrong number of entries. want=3, got=%d (%+v)", len(lines), lines)
+	}
+
+	tests := []struct {
+		offset int
+		line   int
+	}{
+		{0, 1}, {3, 1}, {4, 2}, {7, 2}, {9, 4}, {100, 4},
+	}
+	for _, tt := range tests {
+		if got := lines.PositionFor(tt.offset).Line; got != tt.line {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.line, got)
+		}
+	}
+
+	lines = lines.Truncate(4)
+	if got := lines.PositionFor(9).Line; got != 1 {
+		t.Errorf("wrong line after truncate. want=1, got=%d", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	concat := func(instructions ...Instructions) Instructions {
+		out := Instructions{}
+		for _, ins := range instructions {
+			out = append(out, ins...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		ins         Instructions
+		expectedErr string
+	}{
+		{
+			concat(Make(OpTrue), Make(OpJumpNotTruthy, 7), Make(OpConstant, 0), Make(OpPop)),
+			"",
+		},
+		{
+			concat(Make(OpConstant, 1))[:2],
+			"offset 0000: OpConstant is truncated, needs 2 operand bytes, got 1",
+		},
+		{
+			concat(Make(OpPop), Instructions{255}),
+			"offset 0001: Opcode 255 undefined",
+		},
+		{
+			concat(Make(OpJump, 2), Make(OpConstant, 0)),
+			"offset 0000: jump target 2 is not on an instruction boundary",
+		},
+		{
+			concat(Make(OpJump, 7), Make(OpConstant, 0)),
+			"offset 0000: jump target 7 is past the end of instructions (6)",
+		},
+		{
+			concat(MakeWide(OpJumpNotTruthy, 6), MakeWide(OpConstant, 0), Make(OpPop)),
+			"",
+		},
+		{
+			concat(Make(OpPop), Instructions{byte(OpWide), byte(OpPop)}),
+			"offset 0001: OpWide can not prefix OpPop, it has no operands",
+		},
+		{
+			concat(MakeWide(OpConstant, 0))[:4],
+			"offset 0000: OpWide OpConstant is truncated, needs 4 operand bytes, got 2",
+		},
+		{
+			Instructions{byte(OpWide)},
+			"offset 0000: OpWide is not followed by an instruction",
+		},
+		{
+			concat(MakeWide(OpJump, 3), Make(OpPop)),
+			"offset 0000: jump target 3 is not on an instruction boundary",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.ins)
+		if tt.expectedErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected error %q, got none", tt.expectedErr)
+			continue
+		}
+		if err.Error() != tt.expectedErr {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedErr, err.Error())
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/ShivankSharma070/go-compiler/token"
+)
+
+// LineEntry marks that instructions starting at Offset were generated from source at Pos
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable maps instruction offsets back to source positions. Entries are sorted by offset,
+// and an entry covers every instruction till the offset of next entry.
+type LineTable []LineEntry
+
+// Add records that the instruction at offset was generated from pos. Consecutive instructions
+// from the same position share a single entry.
+func (lt LineTable) Add(offset int, pos token.Position) LineTable {
+	if !pos.IsValid() {
+		return lt
+	}
+
+	if n := len(lt); n > 0 && lt[n-1].Pos == pos {
+		return lt
+	}
+	return append(lt, LineEntry{Offset: offset, Pos: pos})
+}
+
+// PositionFor returns source position of the instruction containing offset
+func (lt LineTable) PositionFor(offset int) token.Position {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return lt[i-1].Pos
+}
+
+// Truncate drops entries for instructions at or after offset
+func (lt LineTable) Truncate(offset int) LineTable {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset >= offset })
+	return lt[:i]
+}
@@ -0,0 +1,177 @@
+package code
+
+import "fmt"
+
+// Operand index holding the jump target, for opcodes which can transfer control
+var jumpOperands = map[Opcode]int{
+	OpJump:          0,
+	OpJumpNotTruthy: 0,
+	OpIterNext:      0,
+}
+
+// JumpOperand reports which operand of op is a jump target, if any
+func JumpOperand(op Opcode) (int, bool) {
+	i, ok := jumpOperands[op]
+	return i, ok
+}
+
+// VerifyError describes malformed instructions found at Offset
+type VerifyError struct {
+	Offset  int
+	Message string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("offset %04d: %s", e.Offset, e.Message)
+}
+
+// ReadInstruction decodes the instruction starting at offset. It returns the opcode, its
+// operands and the width of the whole instruction in bytes. Unlike ReadOperands it never reads
+// past the end of ins, an undefined opcode or truncated operands are reported as an error.
+// An instruction with the OpWide prefix is returned as the opcode following the prefix, with
+// its wide operands, and the width includes the prefix.
+func ReadInstruction(ins Instructions, offset int) (Opcode, []int, int, error) {
+	operands := make([]int, MaxOperands)
+	op, n, width, err := DecodeInstruction(ins, offset, operands)
+	if err != nil {
+		return op, nil, 0, err
+	}
+	return op, operands[:n], width, nil
+}
+
+// Most operands any instruction has
+const MaxOperands = 2
+
+// DecodeInstruction is ReadInstruction for the VM's dispatch loop, which runs it for every
+// instruction and so must not allocate: the operands are stored into operands, which needs
+// room for MaxOperands, and their number is returned instead.
+func DecodeInstruction(ins Instructions, offset int, operands []int) (op Opcode, n int, width int, err error) {
+	op = Opcode(ins[offset])
+	position := offset + 1
+	l := &layouts[op]
+	widths, width := l.widths, l.width
+
+	if op == OpWide {
+		if position >= len(ins) {
+			return OpWide, 0, 0, fmt.Errorf("OpWide is not followed by an instruction")
+		}
+		op = Opcode(ins[position])
+		position++
+		l = &layouts[op]
+		widths, width = l.wideWidths, l.wideWidth
+		if l.defined && len(widths) == 0 {
+			return op, 0, 0, fmt.Errorf("OpWide can not prefix %s, it has no operands", definitions[op].Name)
+		}
+	}
+	if !l.defined {
+		return op, 0, 0, fmt.Errorf("Opcode %d undefined", op)
+	}
+	if offset+width > len(ins) {
+		name := definitions[op].Name
+		if position-offset == 2 {
+			name = "OpWide " + name
+		}
+		return op, 0, 0, fmt.Errorf("%s is truncated, needs %d operand bytes, got %d", name, width-(position-offset), len(ins)-position)
+	}
+
+	for i, w := range widths {
+		switch w {
+		case 1:
+			operands[i] = int(ins[position])
+		case 2:
+			operands[i] = int(ReadUint16(ins[position:]))
+		case 4:
+			operands[i] = int(ReadUint32(ins[position:]))
+		}
+		position += w
+	}
+	return op, len(widths), width, nil
+}
+
+// Operand widths of every opcode and the width of its whole instruction, plain and with the
+// OpWide prefix, indexed by opcode so that decoding needs no map lookups
+type layout struct {
+	defined    bool
+	widths     []int
+	width      int
+	wideWidths []int
+	wideWidth  int
+}
+
+var layouts = func() (layouts [256]layout) {
+	for op, def := range definitions {
+		if len(def.OperandWidths) > MaxOperands {
+			panic(fmt.Sprintf("%s has more than MaxOperands operands", def.Name))
+		}
+		l := layout{defined: true, widths: def.OperandWidths, wideWidths: WideOperandWidths(def), width: 1, wideWidth: 2}
+		for i := range l.widths {
+			l.width += l.widths[i]
+			l.wideWidth += l.wideWidths[i]
+		}
+		layouts[op] = l
+	}
+	return layouts
+}()
+
+// Verify checks that ins is a well formed instruction stream: every opcode is defined, every
+// instruction has all of its operands and every jump lands on an instruction boundary.
+func Verify(ins Instructions) error {
+	boundaries := make([]bool, len(ins)+1)
+	boundaries[len(ins)] = true // Jumping to the very end is fine
+
+	type jump struct{ offset, target int }
+	jumps := []jump{}
+
+	for offset := 0; offset < len(ins); {
+		op, operands, width, err := ReadInstruction(ins, offset)
+		if err != nil {
+			return &VerifyError{Offset: offset, Message: err.Error()}
+		}
+		boundaries[offset] = true
+
+		if i, ok := JumpOperand(op); ok {
+			jumps = append(jumps, jump{offset: offset, target: operands[i]})
+		}
+		offset += width
+	}
+
+	for _, j := range jumps {
+		if j.target > len(ins) {
+			return &VerifyError{Offset: j.offset, Message: fmt.Sprintf("jump target %d is past the end of instructions (%d)", j.target, len(ins))}
+		}
+		if !boundaries[j.target] {
+			return &VerifyError{Offset: j.offset, Message: fmt.Sprintf("jump target %d is not on an instruction boundary", j.target)}
+		}
+	}
+
+	return nil
+}
+
+// StackEffect returns how many values an instruction pops from and then pushes onto the stack
+func StackEffect(op Opcode, operands []int) (pops, pushes int) {
+	switch op {
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetLocal, OpGetBuiltin, OpGetFree, OpCurrentClosure:
+		return 0, 1
+	case OpAdd, OpSub, OpMul, OpDiv, OpEqual, OpNotEqual, OpGreaterThan, OpIndex,
+		OpGreaterEqual, OpMod, OpBitAnd, OpBitOr, OpBitXor, OpShiftLeft, OpShiftRight:
+		return 2, 1
+	case OpMinus, OpBang, OpBitNot, OpNewCell, OpDeref, OpIter:
+		return 1, 1
+	case OpSlice, OpSetIndex:
+		return 3, 1
+	case OpPop, OpSetGlobal, OpSetGlobalCell, OpSetLocal, OpSetLocalCell, OpSetFree, OpJumpNotTruthy, OpReturnValue:
+		return 1, 0
+	case OpArray, OpHash:
+		return operands[0], 1
+	case OpCall, OpTailCall:
+		return operands[0] + 1, 1
+	case OpClosure:
+		return operands[1], 1
+	case OpIterNext:
+		// The iterator stays, when the jump is taken nothing is pushed
+		return 1, 1 + operands[1]
+	default:
+		// OpJump, OpReturn
+		return 0, 0
+	}
+}
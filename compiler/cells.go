@@ -0,0 +1,189 @@
+package compiler
+
+import (
+	"github.com/ShivankSharma070/go-compiler/ast"
+	"github.com/ShivankSharma070/go-compiler/code"
+)
+
+// Cells
+//
+// A closure gets copies of the variables it captures when it is created. As long as variables
+// never change that is the same as sharing them, but once a captured variable is assigned to,
+// the closure and the function defining the variable would see different values. Such locals
+// live in a cell instead: the local slot holds the cell, closures capture the cell itself and
+// every read and write goes through it.
+//
+//	let x = v     OpNull; OpNewCell; OpSetLocal x; v; OpSetLocalCell x
+//	x             OpGetLocal x; OpDeref           (OpGetFree x; OpDeref in a closure)
+//	x = v         v; OpSetLocalCell x             (v; OpSetFree x in a closure)
+//
+// The cell is created before the value is compiled, so a function stored in the variable can
+// capture the cell it is stored into. Parameters which need a cell are moved into one when the
+// function starts. Closures refer to globals directly, except for globals defined inside of a
+// loop body, which closures capture like locals, see loops.go. Those use OpSetGlobalCell where a
+// local would use OpSetLocalCell.
+
+// Collects names of variables which are assigned to and which are referenced from inside a
+// nested function. Names are not resolved, a shadowed variable counts for all variables with
+// its name, which at worst puts a variable in a cell which did not need one. Also collects the
+// names defined with let outside of nested functions.
+type variableUses struct {
+	assigned map[string]bool
+	captured map[string]bool
+	defined  map[string]bool
+}
+
+func newVariableUses() *variableUses {
+	return &variableUses{assigned: map[string]bool{}, captured: map[string]bool{}, defined: map[string]bool{}}
+}
+
+// Names of the variables defined in body, the body of a function or the whole program, which
+// need a cell: those which are assigned to anywhere in it, nested functions included, and
+// referenced from a nested function
+func cellVariables(body ast.Node) map[string]bool {
+	uses := newVariableUses()
+	uses.visit(body, false)
+
+	cells := map[string]bool{}
+	for name := range uses.assigned {
+		if uses.captured[name] {
+			cells[name] = true
+		}
+	}
+	return cells
+}
+
+// Names of all variables assigned to in program
+func assignedVariables(program *ast.Program) map[string]bool {
+	uses := newVariableUses()
+	uses.visit(program, false)
+	return uses.assigned
+}
+
+// Reports whether node defines a variable with let, other than in a nested function
+func definesVariables(node ast.Node) bool {
+	uses := newVariableUses()
+	uses.visit(node, false)
+	return len(uses.defined) > 0
+}
+
+func (u *variableUses) visit(node ast.Node, nested bool) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			u.visit(s, nested)
+		}
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, s := range node.Statements {
+			u.visit(s, nested)
+		}
+	case *ast.ExpressionStatement:
+		u.visit(node.Expression, nested)
+	case *ast.LetStatement:
+		if !nested {
+			u.defined[node.Name.Value] = true
+		}
+		u.visit(node.Value, nested)
+	case *ast.ReturnStatement:
+		u.visit(node.ReturnValue, nested)
+	case *ast.WhileStatement:
+		u.visit(node.Condition, nested)
+		u.visit(node.Body, nested)
+	case *ast.ForStatement:
+		// Every iteration defines the loop variables afresh, which is not an assignment
+		u.visit(node.Iterable, nested)
+		u.visit(node.Body, nested)
+
+	case *ast.Identifier:
+		if nested {
+			u.captured[node.Value] = true
+		}
+	case *ast.AssignExpression:
+		u.assigned[node.Name.Value] = true
+		u.visit(node.Name, nested)
+		u.visit(node.Value, nested)
+	case *ast.PrefixExpression:
+		u.visit(node.Right, nested)
+	case *ast.InfixExpression:
+		u.visit(node.Left, nested)
+		u.visit(node.Right, nested)
+	case *ast.IfElseExpression:
+		u.visit(node.Condition, nested)
+		u.visit(node.Consequence, nested)
+		u.visit(node.Alternative, nested)
+	case *ast.FunctionExpression:
+		u.visit(node.Body, true)
+	case *ast.CallExpression:
+		u.visit(node.Function, nested)
+		for _, a := range node.Argument {
+			u.visit(a, nested)
+		}
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			u.visit(e, nested)
+		}
+	case *ast.IndexExpression:
+		u.visit(node.Left, nested)
+		u.visit(node.Index, nested)
+	case *ast.SliceExpression:
+		u.visit(node.Left, nested)
+		u.visit(node.Low, nested)
+		u.visit(node.High, nested)
+	case *ast.IndexAssignExpression:
+		// Assigns to an element, not to the variable holding the array or hash
+		u.visit(node.Target, nested)
+		u.visit(node.Value, nested)
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			u.visit(k, nested)
+			u.visit(v, nested)
+		}
+	}
+}
+
+// Moves parameters which need a cell into one, at the start of a function
+func (c *Compiler) boxParameters(parameters []*ast.Identifier) {
+	for _, p := range parameters {
+		symbol, ok := c.symbolTable.Resolve(p.Value)
+		if !ok || symbol.Scope != LocalScope || !symbol.Cell {
+			continue
+		}
+		c.emit(code.OpGetLocal, symbol.Index)
+		c.initSymbol(symbol)
+	}
+}
+
+// Stores the value on top of the stack into a variable which was just defined, in a new cell if
+// it needs one
+func (c *Compiler) initSymbol(s Symbol) {
+	if !s.Cell {
+		c.storeSymbol(s)
+		return
+	}
+
+	c.emit(code.OpNewCell)
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// Stores the value on top of the stack into the variable of symbol
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch {
+	case s.Scope == GlobalScope && s.Cell:
+		c.emit(code.OpSetGlobalCell, s.Index)
+	case s.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case s.Scope == LocalScope && s.Cell:
+		c.emit(code.OpSetLocalCell, s.Index)
+	case s.Scope == LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case s.Scope == FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
@@ -1,26 +1,47 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
-	"sort"
 
 	"github.com/ShivankSharma070/go-compiler/ast"
 	"github.com/ShivankSharma070/go-compiler/code"
 	"github.com/ShivankSharma070/go-compiler/object"
+	"github.com/ShivankSharma070/go-compiler/token"
 )
 
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction // Why we need previous Instruction when we have last instructions ?? That because, when we remove last instruction, we need to keep track of the last instruction in stack
+	lines               code.LineTable
+	wideJumps           bool // Emit jumps with the OpWide prefix, see wide.go
+
+	loops   []*loop // Loops around the code being compiled, innermost last, see loops.go
+	pending int     // Values on the stack which the code being compiled leaves for later, see loops.go
 }
 
+// MaxGlobals is the number of global variables a program can define, the size of the VM's
+// global store
+const MaxGlobals = 1 << 16
+
 type Compiler struct {
 	constants   []object.Object
 	symbolTable *SymbolTable
 
+	interned       map[constantKey]int // Pool index of every integer and string constant
+	reusedLiterals int                 // Literals which were found in interned instead of added
+
 	scope      []CompilationScope
 	scopeIndex int
+
+	position token.Position // Source position of node being compiled, recorded in line table on emit
+
+	optimization OptimizationLevel
+
+	assigned map[string]bool // Variables assigned to anywhere in the program being compiled
+
+	err error // First instruction which could not be encoded, see emit
 }
 
 type EmittedInstruction struct {
@@ -32,6 +53,7 @@ func NewWithState(symTab *SymbolTable, cons []object.Object) *Compiler {
 	comp := New()
 	comp.constants = cons
 	comp.symbolTable = symTab
+	comp.internConstants()
 	return comp
 }
 
@@ -50,6 +72,7 @@ func New() *Compiler {
 	return &Compiler{
 		constants:   []object.Object{},
 		symbolTable: symbolTable,
+		interned:    map[constantKey]int{},
 
 		scope:      []CompilationScope{mainScope},
 		scopeIndex: 0,
@@ -57,14 +80,33 @@ func New() *Compiler {
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if pos := sourcePosition(node); pos.IsValid() {
+		previous := c.position
+		c.position = pos
+		defer func() { c.position = previous }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		if c.optimization >= OptimizeFold {
+			node = foldProgram(node)
 		}
+		c.assigned = assignedVariables(node)
+		c.globalSymbols().cells = cellVariables(node)
+
+		state := c.saveState()
+		symbols := c.symbolTable.snapshot()
+		err := c.compileStatements(node.Statements)
+		if errors.Is(err, errJumpOutOfRange) {
+			c.restoreState(state)
+			c.symbolTable.restore(symbols)
+			c.scope[c.scopeIndex].wideJumps = true
+			err = c.compileStatements(node.Statements)
+		}
+		if err != nil {
+			return err
+		}
+
 	case *ast.BlockStatement:
 		for _, st := range node.Statements {
 			err := c.Compile(st)
@@ -88,30 +130,63 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.loadSymbol(symbol)
 
 	case *ast.LetStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		symbol, err := c.define(node.Name.Value)
+		if err != nil {
+			return err
+		}
+		if symbol.Cell {
+			c.emit(code.OpNull)
+			c.initSymbol(symbol)
+		}
 
-		err := c.Compile(node.Value)
+		err = c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.storeSymbol(symbol)
+
+	case *ast.AssignExpression:
+		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable: %s", node.Name.Value)
+		}
+		switch {
+		case symbol.Scope == BuiltinScope:
+			return fmt.Errorf("cannot assign to builtin %s", node.Name.Value)
+		case symbol.Scope == FunctionScope, symbol.Scope == FreeScope && !symbol.Cell:
+			return fmt.Errorf("cannot assign to %s here", node.Name.Value)
 		}
 
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		// The assignment is an expression, its value is the variable after storing into it
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
+		}
+
+		if node.Operator == "<" || node.Operator == "<=" {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
 			}
+			c.holdValues(1)
 			err = c.Compile(node.Left)
 			if err != nil {
 				return err
 			}
+			c.holdValues(-1)
 
-			c.emit(code.OpGreaterThan)
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterEqual)
+			}
 			return nil
 		}
 
@@ -119,10 +194,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		c.holdValues(1)
 		err = c.Compile(node.Right)
 		if err != nil {
 			return err
 		}
+		c.holdValues(-1)
 
 		switch node.Operator {
 		case "+":
@@ -133,8 +210,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterEqual)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
@@ -148,6 +239,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 		idx := c.addConstant(integer)
 		c.emit(code.OpConstant, idx)
 
+	case *ast.BigIntegerLiteral:
+		integer := &object.BigInteger{Value: node.Value}
+		idx := c.addConstant(integer)
+		c.emit(code.OpConstant, idx)
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		idx := c.addConstant(float)
+		c.emit(code.OpConstant, idx)
+
 	case *ast.StringLiteral:
 		st := &object.String{Value: node.Value}
 		idx := c.addConstant(st)
@@ -171,33 +272,41 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMinus)
 		case "!":
 			c.emit(code.OpBang)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
-			return fmt.Errorf("unkown operator: %s", node.Operator)
+			return fmt.Errorf("unknown operator: %s", node.Operator)
 		}
 
 	case *ast.IfElseExpression:
+		if truthy, ok := literalTruthiness(node.Condition); ok && c.optimization >= OptimizeFold {
+			folded, err := c.compileConstantBranch(truthy, node)
+			if folded || err != nil {
+				return err
+			}
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
 
 		// Emit an `OpJumpNotTruthy` with a bogus value.
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		jumpNotTruthyPos := c.emitJump(code.OpJumpNotTruthy)
 
 		err = c.Compile(node.Consequence)
 		if err != nil {
 			return err
 		}
+		c.blockValue(node.Consequence)
 
-		// Remove OpPop if its there
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
-
-		jumpPos := c.emit(code.OpJump, 999)
+		jumpPos := c.emitJump(code.OpJump)
 
 		afterConsequencePos := len(c.currentInstructions())
-		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		err = c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		if err != nil {
+			return err
+		}
 
 		if node.Alternative == nil {
 			// If alternative is nill, insert a alternative block containing a instruction of generating null value
@@ -207,14 +316,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.blockValue(node.Alternative)
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
-		c.changeOperand(jumpPos, afterAlternativePos)
+		err = c.changeOperand(jumpPos, afterAlternativePos)
+		if err != nil {
+			return err
+		}
 
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
@@ -222,31 +331,27 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
+			c.holdValues(1)
 		}
+		c.holdValues(-len(node.Elements))
 
 		c.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
+		keys := node.SortedKeys()
 		for _, k := range keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
 			}
+			c.holdValues(1)
 			err = c.Compile(node.Pairs[k])
 			if err != nil {
 				return err
 			}
-
+			c.holdValues(1)
 		}
+		c.holdValues(-2 * len(keys))
 
 		c.emit(code.OpHash, len(node.Pairs)*2)
 
@@ -255,51 +360,60 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		c.holdValues(1)
 		err = c.Compile(node.Index)
 		if err != nil {
 			return err
 		}
+		c.holdValues(-1)
 		c.emit(code.OpIndex)
 
-	case *ast.FunctionExpression:
-		c.enterScope()
-
-		if node.Name != "" {
-			c.symbolTable.DefineFunctionName(node.Name)
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
 		}
-
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+		c.holdValues(1)
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+			} else if err := c.Compile(bound); err != nil {
+				return err
+			}
+			c.holdValues(1)
 		}
+		c.holdValues(-3)
+		c.emit(code.OpSlice)
 
-		err := c.Compile(node.Body)
+	case *ast.IndexAssignExpression:
+		err := c.Compile(node.Target.Left)
 		if err != nil {
 			return err
 		}
-
-		// Replace last instruction if opPop with a opReturnValue
-		if c.lastInstructionIs(code.OpPop) {
-			c.replaceLastPopWithReturn()
+		c.holdValues(1)
+		err = c.Compile(node.Target.Index)
+		if err != nil {
+			return err
 		}
-		if !c.lastInstructionIs(code.OpReturnValue) {
-			c.emit(code.OpReturn)
+		c.holdValues(1)
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
 		}
+		c.holdValues(-2)
+		// Like an assignment to a variable, the value is left on the stack
+		c.emit(code.OpSetIndex)
 
-		freeSymbols := c.symbolTable.FreeSymbols
-		numLocals := c.symbolTable.numDefinations
-		instruction := c.leaveScope()
-
-		// This emits opcode to load all stack before loading the function onto it.
-		for _, sym := range freeSymbols {
-			c.loadSymbol(sym)
+	case *ast.FunctionExpression:
+		state := c.saveState()
+		err := c.compileFunction(node, false)
+		if errors.Is(err, errJumpOutOfRange) {
+			c.restoreState(state)
+			err = c.compileFunction(node, true)
 		}
-
-		compiledFunction := &object.CompiledFunction{
-			Instructions:  instruction,
-			NumLocals:     numLocals,
-			NumParameters: len(node.Parameters),
+		if err != nil {
+			return err
 		}
-		c.emit(code.OpClosure, c.addConstant(compiledFunction), len(freeSymbols))
 
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
@@ -313,27 +427,241 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		c.holdValues(1)
 
 		for _, a := range node.Argument {
 			err := c.Compile(a)
 			if err != nil {
 				return err
 			}
+			c.holdValues(1)
 		}
+		c.holdValues(-1 - len(node.Argument))
 
 		c.emit(code.OpCall, len(node.Argument))
+
+	case *ast.WhileStatement:
+		return c.compileWhile(node)
+
+	case *ast.ForStatement:
+		return c.compileFor(node)
+
+	case *ast.BreakStatement:
+		return c.compileBreak()
+
+	case *ast.ContinueStatement:
+		return c.compileContinue()
 	}
 
+	return c.err
+}
+
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	for _, s := range statements {
+		err := c.Compile(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compiles the body of a function in a scope of its own and emits the closure
+func (c *Compiler) compileFunction(node *ast.FunctionExpression, wideJumps bool) error {
+	c.enterScope()
+	c.scope[c.scopeIndex].wideJumps = wideJumps
+	c.symbolTable.cells = cellVariables(node.Body)
+
+	// A function refers to itself directly, unless the variable it is stored in can change
+	if node.Name != "" && !c.assigned[node.Name] {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	c.boxParameters(node.Parameters)
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	// The value of a final expression statement is returned, replace its OpPop
+	if n := len(node.Body.Statements); n > 0 {
+		if _, ok := node.Body.Statements[n-1].(*ast.ExpressionStatement); ok {
+			c.replaceLastPopWithReturn()
+		}
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+	if c.optimization >= OptimizePeephole {
+		c.peephole()
+	}
+	c.markTailCalls()
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinations
+	localNames := c.symbolTable.DefinedNames()
+	lines := c.scope[c.scopeIndex].lines
+	instruction := c.leaveScope()
+
+	// This emits opcode to load all stack before loading the function onto it.
+	for _, sym := range freeSymbols {
+		c.loadCapture(sym)
+	}
+
+	compiledFunction := &object.CompiledFunction{
+		Instructions:  instruction,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Name:          node.Name,
+		Lines:         lines,
+		LocalNames:    localNames,
+		FreeNames:     symbolNames(freeSymbols),
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFunction), len(freeSymbols))
+	return nil
+}
+
+// Compiles only the branch of an if expression which runs, as its condition is known. A branch
+// which defines variables is never dropped: names defined in a branch are visible after the if,
+// so dropping it would change what those names refer to.
+func (c *Compiler) compileConstantBranch(truthy bool, node *ast.IfElseExpression) (bool, error) {
+	branch, dropped := node.Consequence, node.Alternative
+	if !truthy {
+		branch, dropped = node.Alternative, node.Consequence
+	}
+	if dropped != nil && definesVariables(dropped) {
+		return false, nil
+	}
+
+	if branch == nil {
+		c.emit(code.OpNull)
+		return true, nil
+	}
+
+	err := c.Compile(branch)
+	if err != nil {
+		return true, err
+	}
+	c.blockValue(branch)
+	return true, nil
+}
+
+// Leaves the value of a block compiled as a branch of an if expression on the stack, which is
+// the value of its final expression statement, so its OpPop is removed, or null if it does not
+// end in one. Nothing is pushed after return, break or continue, control never gets there.
+func (c *Compiler) blockValue(block *ast.BlockStatement) {
+	if n := len(block.Statements); n > 0 {
+		switch block.Statements[n-1].(type) {
+		case *ast.ExpressionStatement:
+			c.removeLastPop()
+			return
+		case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+			return
+		}
+	}
+	c.emit(code.OpNull)
+}
+
+// && and || only evaluate their right operand if the left one does not decide the result, which
+// is always a boolean
+//
+//	a && b                          a || b
+//
+//	       a                               a
+//	       OpJumpNotTruthy false           OpJumpNotTruthy right
+//	       b                               OpJump true
+//	       OpJumpNotTruthy false    right: b
+//	true:  OpTrue                          OpJumpNotTruthy false
+//	       OpJump end               true:  OpTrue
+//	false: OpFalse                         OpJump end
+//	end:                            false: OpFalse
+//	                                end:
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	toFalse := []int{c.emitJump(code.OpJumpNotTruthy)}
+	toTrue := []int{}
+	if node.Operator == "||" {
+		toTrue = append(toTrue, c.emitJump(code.OpJump))
+		err = c.changeOperand(toFalse[0], len(c.currentInstructions()))
+		if err != nil {
+			return err
+		}
+		toFalse = toFalse[:0]
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	toFalse = append(toFalse, c.emitJump(code.OpJumpNotTruthy))
+
+	err = c.patchJumps(toTrue, len(c.currentInstructions()))
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpTrue)
+	end := c.emitJump(code.OpJump)
+
+	err = c.patchJumps(toFalse, len(c.currentInstructions()))
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpFalse)
+
+	return c.changeOperand(end, len(c.currentInstructions()))
+}
+
+// Points every jump in jumps at target
+func (c *Compiler) patchJumps(jumps []int, target int) error {
+	for _, pos := range jumps {
+		err := c.changeOperand(pos, target)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Bytecode returns the compiled program. Top level instructions are only complete at this
+// point, so this is where they go through the peephole optimizer.
 func (c *Compiler) Bytecode() *Bytecode {
+	if c.optimization >= OptimizePeephole && c.scopeIndex == 0 {
+		c.peephole()
+	}
+
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.scope[c.scopeIndex].lines,
+		GlobalNames:  c.globalSymbols().DefinedNames(),
 	}
 }
 
+// Symbol table of the global scope, the compiler may be inside of a function
+func (c *Compiler) globalSymbols() *SymbolTable {
+	st := c.symbolTable
+	for st.outer != nil {
+		st = st.outer
+	}
+	return st
+}
+
+func symbolNames(symbols []Symbol) []string {
+	var names []string
+	for _, s := range symbols {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	if len(c.currentInstructions()) == 0 {
 		return false
@@ -343,7 +671,9 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 }
 
 func (c *Compiler) removeLastPop() {
-	c.scope[c.scopeIndex].instructions = c.currentInstructions()[:c.scope[c.scopeIndex].lastInstruction.position]
+	position := c.scope[c.scopeIndex].lastInstruction.position
+	c.scope[c.scopeIndex].lines = c.scope[c.scopeIndex].lines.Truncate(position)
+	c.scope[c.scopeIndex].instructions = c.currentInstructions()[:position]
 	c.scope[c.scopeIndex].lastInstruction = c.scope[c.scopeIndex].previousInstruction
 }
 
@@ -353,21 +683,57 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
-func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+// Patches the target of a jump emitted by emitJump. A jump without the OpWide prefix can not
+// grow in place, so a target which does not fit is reported as errJumpOutOfRange.
+func (c *Compiler) changeOperand(opPos int, operand int) error {
+	ins := c.currentInstructions()
+	op, operands, _, _ := code.ReadInstruction(ins, opPos)
+	target, _ := code.JumpOperand(op)
+	operands[target] = operand
+
+	if code.Opcode(ins[opPos]) == code.OpWide {
+		c.replaceInstruction(opPos, code.MakeWide(op, operands...))
+		return nil
+	}
+	if code.NeedsWide(op, operands...) {
+		return errJumpOutOfRange
+	}
 
-	c.replaceInstruction(opPos, newInstruction)
+	c.replaceInstruction(opPos, code.Make(op, operands...))
+	return nil
 }
 
+// Adds obj to the constant pool and returns its index. Numbers and strings are interned, so
+// every occurrence of the same literal shares one slot.
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := internKey(obj)
+	if ok {
+		if index, exists := c.interned[key]; exists {
+			c.reusedLiterals++
+			return index
+		}
+	}
+
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	index := len(c.constants) - 1
+	if ok {
+		c.interned[key] = index
+	}
+	return index
 }
 
+// Emits an instruction, using the OpWide prefix if an operand needs it. Operands which do not
+// fit even then are recorded in c.err, which Compile returns.
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	if err := code.CheckOperands(op, operands...); err != nil && c.err == nil {
+		c.err = fmt.Errorf("program too large: %s", err)
+	}
+	return c.emitInstruction(op, code.Make(op, operands...))
+}
+
+func (c *Compiler) emitInstruction(op code.Opcode, ins code.Instructions) int {
 	pos := c.addInstruction(ins)
+	c.scope[c.scopeIndex].lines = c.scope[c.scopeIndex].lines.Add(pos, c.position)
 
 	c.setLastInstruction(op, pos)
 	return pos
@@ -390,6 +756,8 @@ func (c *Compiler) addInstruction(ins []byte) int {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Lines        code.LineTable // Line table of the top level instructions
+	GlobalNames  []string       // Names of globals by index, only used for disassembly
 }
 
 func (c *Compiler) currentInstructions() code.Instructions {
@@ -424,7 +792,24 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scope[c.scopeIndex].lastInstruction.op = code.OpReturnValue
 }
 
+// Defines a variable in the current scope, globals are limited to MaxGlobals
+func (c *Compiler) define(name string) (Symbol, error) {
+	symbol := c.symbolTable.Define(name)
+	if symbol.Scope == GlobalScope && symbol.Index >= MaxGlobals {
+		return symbol, fmt.Errorf("too many global variables, at most %d can be defined", MaxGlobals)
+	}
+	return symbol, nil
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
+	c.loadCapture(s)
+	if s.Cell {
+		c.emit(code.OpDeref)
+	}
+}
+
+// Loads what a closure captures for symbol, which is the cell itself for variables in a cell
+func (c *Compiler) loadCapture(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
 		c.emit(code.OpGetGlobal, s.Index)
@@ -438,3 +823,25 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpCurrentClosure)
 	}
 }
+
+// Source position which instructions generated for node are attributed to. Operators point at
+// the operator itself, rather than the start of their left operand.
+func sourcePosition(node ast.Node) token.Position {
+	switch node := node.(type) {
+	case nil:
+		return token.Position{}
+	case *ast.InfixExpression:
+		return node.Token.Pos
+	case *ast.IndexExpression:
+		return node.Token.Pos
+	case *ast.SliceExpression:
+		return node.Token.Pos
+	case *ast.IndexAssignExpression:
+		return node.Token.Pos
+	case *ast.Program, *ast.BlockStatement:
+		// Leave attribution to the statements inside
+		return token.Position{}
+	default:
+		return node.Pos()
+	}
+}
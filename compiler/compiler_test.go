@@ -1,7 +1,10 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/ShivankSharma070/go-compiler/ast"
@@ -15,6 +18,7 @@ type compilerTestCase struct {
 	input                string
 	expectedConstants    []any
 	expectedInstructions []code.Instructions
+	optimization         OptimizationLevel
 }
 
 func TestIntegerArithmetic(t *testing.T) {
@@ -78,6 +82,96 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "5%2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "5&2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitAnd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "5|2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitOr),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "5^2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitXor),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "5<<2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShiftLeft),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "5>>2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShiftRight),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "~1",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestFloatLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 + 2; 1.5 * 2.0",
+			expectedConstants: []any{1.5, 2, 2.0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTest(t, tests)
@@ -196,6 +290,79 @@ func TestBooleanExpression(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []any{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 && 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpJump, 17),
+				// 0016
+				code.Make(code.OpFalse),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 || 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 9),
+				// 0006
+				code.Make(code.OpJump, 15),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpJumpNotTruthy, 19),
+				// 0015
+				code.Make(code.OpTrue),
+				// 0016
+				code.Make(code.OpJump, 20),
+				// 0019
+				code.Make(code.OpFalse),
+				// 0020
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTest(t, tests)
@@ -455,14 +622,14 @@ func TestIndexOperator(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             `[1,2,3][1+1]`,
-			expectedConstants: []any{1, 2, 3, 1, 1},
+			expectedConstants: []any{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -470,18 +637,45 @@ func TestIndexOperator(t *testing.T) {
 		},
 		{
 			input:             `{1:2}[2-1]`,
-			expectedConstants: []any{1, 2, 2, 1},
+			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `[1,2,3][1:]`,
+			expectedConstants: []any{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{1:2}[3] = 4`,
+			expectedConstants: []any{1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTest(t, tests)
@@ -737,7 +931,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -891,16 +1085,15 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -920,21 +1113,20 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
 					code.Make(code.OpClosure, 1, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -946,11 +1138,131 @@ func TestRecursiveFunctions(t *testing.T) {
 	runCompilerTest(t, tests)
 }
 
+func TestAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let x = 1; x = 2 }",
+			expectedConstants: []any{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let n = 0; fn() { n = n + 1 } }",
+			expectedConstants: []any{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpNull),
+					code.Make(code.OpNewCell),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocalCell, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { let get = fn() { a }; a = 1; get }",
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpNewCell),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocalCell, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A function stored in a variable which is reassigned calls itself through it
+			input: "let f = fn() { f() }; f = 1;",
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
 func runCompilerTest(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
 		compiler := New()
+		compiler.SetOptimizationLevel(tt.optimization)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Errorf("Compiler error: %s", err)
@@ -991,6 +1303,11 @@ func testConstants(t *testing.T, expected []any, actual []object.Object) error {
 			if err != nil {
 				t.Errorf("constant %d - testIntegerObject failed, %s", i, err)
 			}
+		case float64:
+			f, ok := actual[i].(*object.Float)
+			if !ok || f.Value != constant {
+				t.Errorf("constant %d - not float %g, got %T (%+v)", i, constant, actual[i], actual[i])
+			}
 		case []code.Instructions:
 			fn, ok := actual[i].(*object.CompiledFunction)
 			if !ok {
@@ -1053,3 +1370,704 @@ func testStringObject(value string, actual object.Object) error {
 
 	return nil
 }
+
+func TestCompiledFunctionDebugInfo(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+fn() { 1 }`
+
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+	add, ok := bytecode.Constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 0 is not a function, got %T", bytecode.Constants[0])
+	}
+	if add.Name != "add" {
+		t.Errorf("wrong function name. want=%q, got=%q", "add", add.Name)
+	}
+
+	// OpGetLocal 0, OpGetLocal 1, OpAdd, OpReturnValue
+	if pos := add.Lines.PositionFor(0); pos.Line != 2 || pos.Column != 3 {
+		t.Errorf("wrong position for first instruction, got %s", pos)
+	}
+	if pos := add.Lines.PositionFor(4); pos.Line != 2 || pos.Column != 5 {
+		t.Errorf("wrong position for OpAdd, got %s", pos)
+	}
+
+	anonymous := bytecode.Constants[2].(*object.CompiledFunction)
+	if anonymous.Name != "" {
+		t.Errorf("anonymous function has a name %q", anonymous.Name)
+	}
+
+	if pos := bytecode.Lines.PositionFor(len(bytecode.Instructions) - 1); pos.Line != 4 {
+		t.Errorf("wrong position for last top level instruction, got %s", pos)
+	}
+}
+
+func TestConstantInterning(t *testing.T) {
+	comp := New()
+	err := comp.Compile(parse(`let a = "x"; let f = fn() { 1 + 2 + "x" }; 1; f; fn() { 1 };`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	stats := comp.ConstantPoolStats()
+	expected := ConstantPoolStats{Size: 5, Integers: 2, Strings: 1, Functions: 2, Reused: 3}
+	if stats != expected {
+		t.Errorf("wrong stats. want=%+v, got=%+v", expected, stats)
+	}
+
+	// A second compiler sharing the pool, as the REPL does for every line, reuses its slots
+	bytecode := comp.Bytecode()
+	next := NewWithState(comp.symbolTable, bytecode.Constants)
+	err = next.Compile(parse(`"x"; 2; 3;`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 5),
+		code.Make(code.OpPop),
+	}, next.Bytecode().Instructions)
+	if err != nil {
+		t.Errorf("testInstructions failed: %s", err)
+	}
+
+	stats = next.ConstantPoolStats()
+	expected = ConstantPoolStats{Size: 6, Integers: 3, Strings: 1, Functions: 2, Reused: 2}
+	if stats != expected {
+		t.Errorf("wrong stats after second compile. want=%+v, got=%+v", expected, stats)
+	}
+
+	// Floats are interned apart from integers of the same value
+	comp = New()
+	err = comp.Compile(parse(`0.5; 1; 1.0; 0.5`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	stats = comp.ConstantPoolStats()
+	expected = ConstantPoolStats{Size: 3, Integers: 1, Floats: 2, Reused: 1}
+	if stats != expected {
+		t.Errorf("wrong stats for floats. want=%+v, got=%+v", expected, stats)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1 + 2 * 3 - 10 / 5`,
+			expectedConstants: []any{5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `-(2 - 5); !true; !!5; 1 < 2 == true; "mon" + "key"`,
+			expectedConstants: []any{3, "monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Only the literal parts are folded, errors are left for run time
+			input:             `let x = 1; x + (2 + 3); 1 / 0; 1 + "a"`,
+			expectedConstants: []any{1, 5, 0, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `if (1 > 2) { 10 } else { 20 }; if (false) { 10 }; if ("yes") { 30 }`,
+			expectedConstants: []any{20, 30},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Shifting by a negative count fails at run time
+			input:             `~(7 % 4 << 2 | 1); 1 <= 2; "a" < "b"; "a" == "b"; 1 >> -1`,
+			expectedConstants: []any{-14, 1, -1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpShiftRight),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Mixed with an integer the result is a float, dividing by zero gives an infinity
+			input:             `-1.5 * 2 + 1; 3 / 0.0; 1 == 1.0; !0.0`,
+			expectedConstants: []any{-2.0, math.Inf(1)},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Whether an overflow gives a big integer or an error is up to the VM
+			input:             `9223372036854775807 + 1; 3037000500 * 3037000500; 1 << 62`,
+			expectedConstants: []any{9223372036854775807, 1, 3037000500, 4611686018427387904},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The right operand is dropped once the left one decides the result
+			input:             `let x = 1; false && x; 1 || x; true && "a"; x && true`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpFalse),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpTrue),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0018
+				code.Make(code.OpTrue),
+				// 0019
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0022
+				code.Make(code.OpTrue),
+				// 0023
+				code.Make(code.OpJump, 27),
+				// 0026
+				code.Make(code.OpFalse),
+				// 0027
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { if (true) { return 1 + 1; } }`,
+			expectedConstants: []any{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A dropped branch would take the definition of x with it
+			input:             `if (false) { let x = 1; }; 2`,
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpConstant, 1),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `if (false) { fn() { let y = 1; y } }; 2`,
+			expectedConstants: []any{2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for i := range tests {
+		tests[i].optimization = OptimizeFold
+	}
+	runCompilerTest(t, tests)
+}
+
+func TestConstantFoldingKeepsOriginalTree(t *testing.T) {
+	program := parse(`if (1 + 2 > 2) { "a" + "b" }`)
+	before := program.String()
+
+	compiler := New()
+	compiler.SetOptimizationLevel(OptimizeFold)
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	if program.String() != before {
+		t.Errorf("program was modified. want=%q, got=%q", before, program.String())
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input     string
+		tailCalls int
+		calls     int
+	}{
+		{`let f = fn(x) { f(x) };`, 1, 0},
+		{`let f = fn(x) { if (x) { f(x) } else { len(x) } };`, 2, 0},
+		{`let f = fn(x) { if (x) { return f(x); } f(x) + 1 };`, 1, 1},
+		{`let f = fn(x) { let y = f(x); y };`, 0, 1},
+		{`let f = fn(x) { [f(x)] };`, 0, 1},
+		{`let f = fn(x) { f(f(x)) };`, 1, 1},
+		{`let f = fn(x) { x }; f(1);`, 0, 0},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		tailCalls, calls := 0, 0
+		for _, constant := range comp.Bytecode().Constants {
+			fn, ok := constant.(*object.CompiledFunction)
+			if !ok {
+				continue
+			}
+			for offset := 0; offset < len(fn.Instructions); {
+				op, _, width, err := code.ReadInstruction(fn.Instructions, offset)
+				if err != nil {
+					t.Fatalf("bad instructions: %s", err)
+				}
+				switch op {
+				case code.OpTailCall:
+					tailCalls++
+				case code.OpCall:
+					calls++
+				}
+				offset += width
+			}
+		}
+
+		if tailCalls != tt.tailCalls || calls != tt.calls {
+			t.Errorf("%q: want %d tail calls and %d calls, got %d and %d", tt.input, tt.tailCalls, tt.calls, tailCalls, calls)
+		}
+	}
+}
+
+// Monkey source for n statements made by format, which gets a distinct identifier as %[1]s and
+// the index as %[2]d
+func repeatStatements(n int, format string) string {
+	var out strings.Builder
+	for i := range n {
+		fmt.Fprintf(&out, format, identifier(i), i)
+	}
+	return out.String()
+}
+
+// Identifiers can not contain digits, so the index is spelled out in letters
+func identifier(i int) string {
+	name := []byte{'v'}
+	for {
+		name = append(name, byte('a'+i%26))
+		i /= 26
+		if i == 0 {
+			return string(name)
+		}
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	tests := []struct {
+		input    string
+		function int // Constant holding the function to check, -1 for top level instructions
+		suffix   []code.Instructions
+	}{
+		{
+			"let f = fn() { " + repeatStatements(300, "let %[1]s = 0; ") + identifier(299) + " };",
+			1,
+			[]code.Instructions{
+				code.MakeWide(code.OpGetLocal, 299),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"let g = fn(" + strings.TrimSuffix(repeatStatements(300, "%[1]s, "), ", ") + ") { 0 };" +
+				"let f = fn() { g(" + strings.Repeat("0, ", 299) + "0) };",
+			2,
+			[]code.Instructions{
+				code.MakeWide(code.OpTailCall, 300),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			repeatStatements(65537, "%[2]d; "),
+			-1,
+			[]code.Instructions{
+				code.Make(code.OpConstant, 65535),
+				code.Make(code.OpPop),
+				code.MakeWide(code.OpConstant, 65536),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		ins := bytecode.Instructions
+		if tt.function >= 0 {
+			ins = bytecode.Constants[tt.function].(*object.CompiledFunction).Instructions
+		}
+		suffix := concatInstructions(tt.suffix)
+		if !bytes.HasSuffix(ins, suffix) {
+			t.Errorf("instructions do not end with %q, got %q", suffix, ins[max(0, len(ins)-len(suffix)):])
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { if (false) { break; } continue; }",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0008 break
+				code.Make(code.OpJump, 22),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016 continue
+				code.Make(code.OpJump, 0),
+				// 0019
+				code.Make(code.OpJump, 0),
+				// 0022 a loop has no value
+				code.Make(code.OpNull),
+				// 0023
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The key and value are pushed in order, the value is on top. break pops the key
+			// which is waiting to go into the array.
+			input: "fn(h) { for (k, v in h) { [k, if (v) { break; }] } }",
+			expectedConstants: []any{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpIter),
+					// 0003
+					code.Make(code.OpIterNext, 33, 2),
+					// 0007
+					code.Make(code.OpSetLocal, 2),
+					// 0009
+					code.Make(code.OpSetLocal, 1),
+					// 0011
+					code.Make(code.OpGetLocal, 1),
+					// 0013
+					code.Make(code.OpGetLocal, 2),
+					// 0015
+					code.Make(code.OpJumpNotTruthy, 25),
+					// 0018
+					code.Make(code.OpPop),
+					// 0019
+					code.Make(code.OpJump, 33),
+					// 0022
+					code.Make(code.OpJump, 26),
+					// 0025
+					code.Make(code.OpNull),
+					// 0026
+					code.Make(code.OpArray, 2),
+					// 0029
+					code.Make(code.OpPop),
+					// 0030
+					code.Make(code.OpJump, 3),
+					// 0033
+					code.Make(code.OpPop),
+					// 0034
+					code.Make(code.OpNull),
+					// 0035
+					code.Make(code.OpPop),
+					// 0036
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A global defined in a loop is captured like a local
+			input: "for (x in []) { fn() { x } }",
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpArray, 0),
+				// 0003
+				code.Make(code.OpIter),
+				// 0004
+				code.Make(code.OpIterNext, 22, 1),
+				// 0008
+				code.Make(code.OpSetGlobal, 0),
+				// 0011
+				code.Make(code.OpGetGlobal, 0),
+				// 0014
+				code.Make(code.OpClosure, 0, 1),
+				// 0018
+				code.Make(code.OpPop),
+				// 0019
+				code.Make(code.OpJump, 4),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// and put in a cell if it is assigned to as well
+			input: "for (x in []) { fn() { x = 1 } }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpDeref),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpArray, 0),
+				// 0003
+				code.Make(code.OpIter),
+				// 0004
+				code.Make(code.OpIterNext, 23, 1),
+				// 0008
+				code.Make(code.OpNewCell),
+				// 0009
+				code.Make(code.OpSetGlobal, 0),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpClosure, 1, 1),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpJump, 4),
+				// 0023
+				code.Make(code.OpPop),
+				// 0024
+				code.Make(code.OpNull),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestLoopControlOutsideOfLoop(t *testing.T) {
+	// The parser rejects these, but the compiler must not trust the AST it is given
+	program := &ast.Program{Statements: []ast.Statement{&ast.BreakStatement{}}}
+
+	err := New().Compile(program)
+	if err == nil || err.Error() != "break outside of a loop" {
+		t.Errorf("wrong error. want=%q, got=%v", "break outside of a loop", err)
+	}
+}
+
+func TestWideJumps(t *testing.T) {
+	// Each statement of the consequence takes 4 bytes, so it ends past offset 65535
+	consequence := repeatStatements(20000, "%[2]d; ")
+
+	tests := []struct {
+		input     string
+		function  int // Constant holding the function to check, -1 for top level instructions
+		constants int
+	}{
+		{"let x = true; if (x) { " + consequence + "} else { 1 }; 2;", -1, 20000},
+		{"let f = fn(x) { if (x) { " + consequence + "} }; 2;", 20000, 20001},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		ins := bytecode.Instructions
+		if tt.function >= 0 {
+			fn, ok := bytecode.Constants[tt.function].(*object.CompiledFunction)
+			if !ok {
+				t.Fatalf("constant %d is not a function: %T", tt.function, bytecode.Constants[tt.function])
+			}
+			ins = fn.Instructions
+		}
+
+		err = code.Verify(ins)
+		if err != nil {
+			t.Fatalf("invalid instructions: %s", err)
+		}
+
+		jumps := 0
+		for offset := 0; offset < len(ins); {
+			op, _, width, _ := code.ReadInstruction(ins, offset)
+			if _, ok := code.JumpOperand(op); ok {
+				jumps++
+				if code.Opcode(ins[offset]) != code.OpWide {
+					t.Errorf("jump at %d is not wide", offset)
+				}
+			}
+			offset += width
+		}
+		if jumps != 2 {
+			t.Errorf("wrong number of jumps. want=2, got=%d", jumps)
+		}
+
+		// Nothing compiled by the first attempt may be left behind
+		if len(bytecode.Constants) != tt.constants {
+			t.Errorf("wrong number of constants. want=%d, got=%d", tt.constants, len(bytecode.Constants))
+		}
+		if len(bytecode.GlobalNames) != 1 {
+			t.Errorf("wrong globals. want 1, got=%v", bytecode.GlobalNames)
+		}
+	}
+}
+
+func TestOperandsOutOfRange(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{
+			"fn() { " + repeatStatements(65537, "let %[1]s = 0; ") + "}",
+			"program too large: OpSetLocal operand 65536 does not fit in 2 bytes",
+		},
+		{
+			repeatStatements(65537, "let %[1]s = 0; "),
+			"too many global variables, at most 65536 can be defined",
+		},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected error %q, got none", tt.expectedErr)
+			continue
+		}
+		if err.Error() != tt.expectedErr {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedErr, err.Error())
+		}
+	}
+}
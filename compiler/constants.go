@@ -0,0 +1,75 @@
+package compiler
+
+import (
+	"math"
+
+	"github.com/ShivankSharma070/go-compiler/object"
+)
+
+// Identifies an interned constant by its type and value
+type constantKey struct {
+	typ     object.ObjectType
+	integer int64
+	float   uint64 // Bits of the value, so 0.0 and -0.0 stay apart while NaN is interned
+	str     string
+}
+
+// Only immutable literals are interned, compiled functions always get a slot of their own
+func internKey(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{typ: obj.Type(), integer: obj.Value}, true
+	case *object.BigInteger:
+		// Kept apart from Integers by the string, which is empty for those
+		return constantKey{typ: obj.Type(), str: obj.Value.String()}, true
+	case *object.Float:
+		return constantKey{typ: obj.Type(), float: math.Float64bits(obj.Value)}, true
+	case *object.String:
+		return constantKey{typ: obj.Type(), str: obj.Value}, true
+	default:
+		return constantKey{}, false
+	}
+}
+
+// Rebuilds the index of interned constants from the constant pool
+func (c *Compiler) internConstants() {
+	c.interned = map[constantKey]int{}
+	for i, constant := range c.constants {
+		if key, ok := internKey(constant); ok {
+			if _, exists := c.interned[key]; !exists {
+				c.interned[key] = i
+			}
+		}
+	}
+}
+
+// ConstantPoolStats describes the constant pool of a compiler
+type ConstantPoolStats struct {
+	Size      int // Slots in use, past 65536 OpConstant needs the OpWide prefix
+	Integers  int
+	Floats    int
+	Strings   int
+	Functions int
+
+	// Literals compiled by this compiler which reused an existing slot instead of adding one.
+	// A compiler created with NewWithState only counts its own literals.
+	Reused int
+}
+
+// ConstantPoolStats reports how the constant pool is used so far
+func (c *Compiler) ConstantPoolStats() ConstantPoolStats {
+	stats := ConstantPoolStats{Size: len(c.constants), Reused: c.reusedLiterals}
+	for _, constant := range c.constants {
+		switch constant.(type) {
+		case *object.Integer, *object.BigInteger:
+			stats.Integers++
+		case *object.Float:
+			stats.Floats++
+		case *object.String:
+			stats.Strings++
+		case *object.CompiledFunction:
+			stats.Functions++
+		}
+	}
+	return stats
+}
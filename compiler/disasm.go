@@ -0,0 +1,201 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ShivankSharma070/go-compiler/code"
+	"github.com/ShivankSharma070/go-compiler/object"
+)
+
+// Disassemble renders a whole program in human readable form: the top level instructions,
+// every compiled function in the constant pool and the constant pool itself. Instructions are
+// annotated with the constant, builtin or variable they refer to, and jump targets are shown as
+// labels. Bytecode is never trusted, anything malformed is printed as an error in place.
+func Disassemble(bc *Bytecode) string {
+	d := &disassembler{bc: bc}
+
+	main := &object.CompiledFunction{Instructions: bc.Instructions, Name: "<main>"}
+	fmt.Fprintf(&d.out, "== %s (globals %d) ==\n", main.Name, len(bc.GlobalNames))
+	d.function(main)
+
+	for i, constant := range bc.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&d.out, "\n== constant %d: %s (params %d, locals %d, free %d) ==\n",
+			i, functionName(fn), fn.NumParameters, fn.NumLocals, len(fn.FreeNames))
+		d.function(fn)
+	}
+
+	if len(bc.Constants) > 0 {
+		fmt.Fprintf(&d.out, "\n== constants ==\n")
+		for i, constant := range bc.Constants {
+			fmt.Fprintf(&d.out, "%4d  %-8s %s\n", i, typeName(constant), d.constant(i))
+		}
+	}
+
+	return d.out.String()
+}
+
+type disassembler struct {
+	bc  *Bytecode
+	out bytes.Buffer
+}
+
+func (d *disassembler) function(fn *object.CompiledFunction) {
+	ins := fn.Instructions
+	labels := jumpLabels(ins)
+
+	for offset := 0; offset < len(ins); {
+		if label, ok := labels[offset]; ok {
+			fmt.Fprintf(&d.out, "%s:\n", label)
+		}
+
+		op, operands, width, err := code.ReadInstruction(ins, offset)
+		if err != nil {
+			fmt.Fprintf(&d.out, "%04d  ERROR: %s\n", offset, err)
+			return
+		}
+		def, _ := code.Lookup(op)
+
+		text := def.Name
+		if code.Opcode(ins[offset]) == code.OpWide {
+			text = "OpWide " + text
+		}
+		jump, isJump := code.JumpOperand(op)
+		for i, operand := range operands {
+			if label, ok := labels[operand]; ok && isJump && i == jump {
+				text += " " + label
+			} else {
+				text += fmt.Sprintf(" %d", operand)
+			}
+		}
+
+		if note := d.annotation(fn, op, operands); note != "" {
+			fmt.Fprintf(&d.out, "%04d  %-24s ; %s\n", offset, text, note)
+		} else {
+			fmt.Fprintf(&d.out, "%04d  %s\n", offset, text)
+		}
+		offset += width
+	}
+
+	// A jump to the very end has no instruction to hang its label on
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&d.out, "%s:\n", label)
+	}
+}
+
+// Describes what an instruction refers to, empty if there is nothing to add
+func (d *disassembler) annotation(fn *object.CompiledFunction, op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant:
+		return d.constant(operands[0])
+
+	case code.OpClosure:
+		if operands[0] < len(d.bc.Constants) {
+			if closure, ok := d.bc.Constants[operands[0]].(*object.CompiledFunction); ok {
+				return "fn " + functionName(closure)
+			}
+		}
+		return "<not a function>"
+
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return "builtin " + object.Builtins[operands[0]].Name
+		}
+		return "<unknown builtin>"
+
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpSetGlobalCell:
+		return nameAt(d.bc.GlobalNames, operands[0])
+
+	case code.OpGetLocal, code.OpSetLocal, code.OpSetLocalCell:
+		return nameAt(fn.LocalNames, operands[0])
+
+	case code.OpGetFree, code.OpSetFree:
+		return nameAt(fn.FreeNames, operands[0])
+
+	case code.OpCurrentClosure:
+		return "fn " + functionName(fn)
+	}
+
+	return ""
+}
+
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.bc.Constants) {
+		return "<invalid constant>"
+	}
+
+	switch constant := d.bc.Constants[index].(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("fn %s/%d", functionName(constant), constant.NumParameters)
+	default:
+		return constant.Inspect()
+	}
+}
+
+// Assigns labels L1, L2, ... to jump targets in order of their offsets. Targets which are not
+// on an instruction boundary get no label, so they are shown as plain offsets.
+func jumpLabels(ins code.Instructions) map[int]string {
+	boundaries := map[int]bool{len(ins): true}
+	targets := map[int]bool{}
+	for offset := 0; offset < len(ins); {
+		op, operands, width, err := code.ReadInstruction(ins, offset)
+		if err != nil {
+			break
+		}
+		boundaries[offset] = true
+		if i, ok := code.JumpOperand(op); ok {
+			targets[operands[i]] = true
+		}
+		offset += width
+	}
+
+	sorted := []int{}
+	for target := range targets {
+		if boundaries[target] {
+			sorted = append(sorted, target)
+		}
+	}
+	sort.Ints(sorted)
+
+	labels := make(map[int]string, len(sorted))
+	for i, target := range sorted {
+		labels[target] = fmt.Sprintf("L%d", i+1)
+	}
+	return labels
+}
+
+func nameAt(names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return ""
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
+func typeName(obj object.Object) string {
+	switch obj.(type) {
+	case *object.Integer, *object.BigInteger:
+		return "int"
+	case *object.Float:
+		return "float"
+	case *object.String:
+		return "string"
+	case *object.CompiledFunction:
+		return "function"
+	default:
+		return string(obj.Type())
+	}
+}
@@ -0,0 +1,93 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ShivankSharma070/go-compiler/code"
+	"github.com/ShivankSharma070/go-compiler/object"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `
+	let total = 0;
+	let add = fn(a, b) { let sum = a + b; fn() { if (sum > 0) { sum } else { len("") } } };
+	add(1, total);
+	`
+
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `== <main> (globals 2) ==
+0000  OpConstant 0             ; 0
+0003  OpSetGlobal 0            ; total
+0006  OpClosure 3 0            ; fn add
+0010  OpSetGlobal 1            ; add
+0013  OpGetGlobal 1            ; add
+0016  OpConstant 4             ; 1
+0019  OpGetGlobal 0            ; total
+0022  OpCall 2
+0024  OpPop
+
+== constant 2: <anonymous> (params 0, locals 0, free 1) ==
+0000  OpGetFree 0              ; sum
+0002  OpConstant 0             ; 0
+0005  OpGreaterThan
+0006  OpJumpNotTruthy L1
+0009  OpGetFree 0              ; sum
+0011  OpJump L2
+L1:
+0014  OpGetBuiltin 0           ; builtin len
+0016  OpConstant 1             ; ""
+0019  OpTailCall 1
+L2:
+0021  OpReturnValue
+
+== constant 3: add (params 2, locals 3, free 0) ==
+0000  OpGetLocal 0             ; a
+0002  OpGetLocal 1             ; b
+0004  OpAdd
+0005  OpSetLocal 2             ; sum
+0007  OpGetLocal 2             ; sum
+0009  OpClosure 2 1            ; fn <anonymous>
+0013  OpReturnValue
+
+== constants ==
+   0  int      0
+   1  string   ""
+   2  function fn <anonymous>/0
+   3  function fn add/2
+   4  int      1
+`
+
+	actual := Disassemble(comp.Bytecode())
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestDisassembleMalformedBytecode(t *testing.T) {
+	bytecode := &Bytecode{
+		Instructions: append(concatInstructions([]code.Instructions{
+			code.Make(code.OpConstant, 7),
+			code.Make(code.OpClosure, 0, 0),
+			code.Make(code.OpJump, 1),
+		}), 0xff),
+		Constants: []object.Object{&object.Integer{Value: 1}},
+	}
+
+	actual := Disassemble(bytecode)
+	for _, want := range []string{
+		"0000  OpConstant 7             ; <invalid constant>",
+		"0003  OpClosure 0 0            ; <not a function>",
+		"0007  OpJump 1\n",
+		"0010  ERROR: Opcode 255 undefined",
+	} {
+		if !strings.Contains(actual, want) {
+			t.Errorf("disassembly does not contain %q, got:\n%s", want, actual)
+		}
+	}
+}
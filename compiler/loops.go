@@ -0,0 +1,181 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/ShivankSharma070/go-compiler/ast"
+	"github.com/ShivankSharma070/go-compiler/code"
+)
+
+// Loops
+//
+//	while (cond) { body }          for (k, v in iterable) { body }
+//
+//	start: cond                            iterable
+//	       OpJumpNotTruthy end             OpIter
+//	       body                     start: OpIterNext end 2
+//	       OpJump start                    OpSetLocal v; OpSetLocal k
+//	end:   OpNull; OpPop                   body
+//	                                       OpJump start
+//	                                end:   OpPop
+//	                                       OpNull; OpPop
+//
+// A for loop keeps its iterator on the stack while it runs, the OpPop at its end removes it. A
+// loop has no value, like other statements it leaves null as the last popped value so that a
+// program ending in a loop gives null rather than whatever the loop popped last. break
+// jumps to end and continue to start. Both can appear where values are still waiting on the
+// stack, e.g. in `1 + if (x) { break; }` the 1 is, so the compiler keeps count of such pending
+// values and pops the ones pushed inside of the loop before jumping.
+//
+// The body of a loop is a block scope, names defined in it can not be used after the loop. The
+// evaluator runs every iteration in an environment of its own, so a closure created in the body
+// holds on to the variables of its iteration. Locals in cells get a new cell every iteration to
+// match, defining a variable always creates its cell.
+
+type loop struct {
+	start   int   // Where continue jumps to
+	pending int   // Pending values at the start of the body, break and continue pop down to this
+	breaks  []int // Jumps to the end of the loop, patched once the end is known
+}
+
+// Records n more values, or fewer if n is negative, which are left on the stack for a later
+// instruction to consume
+func (c *Compiler) holdValues(n int) {
+	c.scope[c.scopeIndex].pending += n
+}
+
+func (c *Compiler) compileWhile(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+	exit := c.emitJump(code.OpJumpNotTruthy)
+
+	breaks, err := c.compileLoopBody(start, nil, node.Body)
+	if err != nil {
+		return err
+	}
+	err = c.patchLoopEnd(append(breaks, exit))
+	if err != nil {
+		return err
+	}
+
+	c.emitNoValue()
+	return nil
+}
+
+func (c *Compiler) compileFor(node *ast.ForStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	c.holdValues(1)
+
+	variables := node.Variables()
+	start := c.emitJump(code.OpIterNext, len(variables))
+
+	breaks, err := c.compileLoopBody(start, variables, node.Body)
+	if err != nil {
+		return err
+	}
+	err = c.patchLoopEnd(append(breaks, start))
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpPop)
+	c.holdValues(-1)
+	c.emitNoValue()
+	return nil
+}
+
+// Pops a null, which the peephole optimizer only keeps where it ends the program
+func (c *Compiler) emitNoValue() {
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+}
+
+// Compiles the body of a loop which starts at start, after defining the variables in it and
+// storing the values on top of the stack into them. Returns the breaks out of the loop.
+func (c *Compiler) compileLoopBody(start int, variables []*ast.Identifier, body *ast.BlockStatement) ([]int, error) {
+	l := &loop{start: start, pending: c.scope[c.scopeIndex].pending}
+	c.scope[c.scopeIndex].loops = append(c.scope[c.scopeIndex].loops, l)
+
+	c.symbolTable.openBlock()
+	err := c.compileLoopVariables(variables)
+	if err == nil {
+		err = c.Compile(body)
+	}
+	c.symbolTable.closeBlock()
+
+	scope := &c.scope[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	if err != nil {
+		return nil, err
+	}
+
+	c.emit(code.OpJump, start)
+	return l.breaks, nil
+}
+
+// The last variable is on top of the stack, so they are stored in reverse order
+func (c *Compiler) compileLoopVariables(variables []*ast.Identifier) error {
+	symbols := make([]Symbol, len(variables))
+	for i, v := range variables {
+		symbol, err := c.define(v.Value)
+		if err != nil {
+			return err
+		}
+		symbols[i] = symbol
+	}
+
+	for i := len(symbols) - 1; i >= 0; i-- {
+		c.initSymbol(symbols[i])
+	}
+	return nil
+}
+
+// Points jumps out of a loop at the next instruction
+func (c *Compiler) patchLoopEnd(jumps []int) error {
+	return c.patchJumps(jumps, len(c.currentInstructions()))
+}
+
+func (c *Compiler) compileBreak() error {
+	l, err := c.innermostLoop("break")
+	if err != nil {
+		return err
+	}
+
+	c.popPending(l)
+	l.breaks = append(l.breaks, c.emitJump(code.OpJump))
+	return nil
+}
+
+func (c *Compiler) compileContinue() error {
+	l, err := c.innermostLoop("continue")
+	if err != nil {
+		return err
+	}
+
+	c.popPending(l)
+	c.emit(code.OpJump, l.start)
+	return nil
+}
+
+// The parser only allows break and continue inside of loops, but an AST can be built by hand
+func (c *Compiler) innermostLoop(statement string) (*loop, error) {
+	loops := c.scope[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil, fmt.Errorf("%s outside of a loop", statement)
+	}
+	return loops[len(loops)-1], nil
+}
+
+// Pops the values pushed inside of the body of l which are still pending
+func (c *Compiler) popPending(l *loop) {
+	for i := c.scope[c.scopeIndex].pending; i > l.pending; i-- {
+		c.emit(code.OpPop)
+	}
+}
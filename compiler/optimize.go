@@ -0,0 +1,403 @@
+package compiler
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/ShivankSharma070/go-compiler/ast"
+	"github.com/ShivankSharma070/go-compiler/object"
+	"github.com/ShivankSharma070/go-compiler/token"
+)
+
+// OptimizationLevel selects which optimizations the compiler performs. Optimized programs give
+// the same results as unoptimized ones, they only run fewer instructions.
+type OptimizationLevel int
+
+const (
+	// OptimizeNone compiles every expression as written
+	OptimizeNone OptimizationLevel = iota
+
+	// OptimizeFold folds operators applied to literals into a single literal, e.g. 1 + 2 into 3
+	// and !true into false, and drops the branch of an if which can never run unless it defines
+	// variables.
+	OptimizeFold
+
+	// OptimizePeephole folds constants and also rewrites wasteful instruction sequences once
+	// code for a function has been generated, see peephole.go
+	OptimizePeephole
+)
+
+// SetOptimizationLevel changes optimizations used for code compiled from now on
+func (c *Compiler) SetOptimizationLevel(level OptimizationLevel) {
+	c.optimization = level
+}
+
+// Returns a copy of program with constant expressions folded. Nodes which do not change are
+// shared with the original tree, which is never modified.
+func foldProgram(program *ast.Program) *ast.Program {
+	folded := &ast.Program{Statements: make([]ast.Statement, len(program.Statements))}
+	for i, s := range program.Statements {
+		folded.Statements[i] = foldStatement(s)
+	}
+	return folded
+}
+
+func foldStatement(s ast.Statement) ast.Statement {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		clone := *s
+		clone.Expression = foldExpression(s.Expression)
+		return &clone
+	case *ast.LetStatement:
+		clone := *s
+		clone.Value = foldExpression(s.Value)
+		return &clone
+	case *ast.ReturnStatement:
+		clone := *s
+		clone.ReturnValue = foldExpression(s.ReturnValue)
+		return &clone
+	case *ast.BlockStatement:
+		return foldBlock(s)
+	case *ast.WhileStatement:
+		clone := *s
+		clone.Condition = foldExpression(s.Condition)
+		clone.Body = foldBlock(s.Body)
+		return &clone
+	case *ast.ForStatement:
+		clone := *s
+		clone.Iterable = foldExpression(s.Iterable)
+		clone.Body = foldBlock(s.Body)
+		return &clone
+	default:
+		return s
+	}
+}
+
+func foldBlock(block *ast.BlockStatement) *ast.BlockStatement {
+	if block == nil {
+		return nil
+	}
+	clone := *block
+	clone.Statements = make([]ast.Statement, len(block.Statements))
+	for i, s := range block.Statements {
+		clone.Statements[i] = foldStatement(s)
+	}
+	return &clone
+}
+
+func foldExpression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		clone := *e
+		clone.Right = foldExpression(e.Right)
+		if folded := foldPrefix(&clone); folded != nil {
+			return folded
+		}
+		return &clone
+
+	case *ast.InfixExpression:
+		clone := *e
+		clone.Left = foldExpression(e.Left)
+		clone.Right = foldExpression(e.Right)
+		if folded := foldInfix(&clone); folded != nil {
+			return folded
+		}
+		return &clone
+
+	case *ast.AssignExpression:
+		clone := *e
+		clone.Value = foldExpression(e.Value)
+		return &clone
+
+	case *ast.IfElseExpression:
+		// Once the condition is a literal, Compile drops the branch which can never run, if it
+		// defines no variables
+		clone := *e
+		clone.Condition = foldExpression(e.Condition)
+		clone.Consequence = foldBlock(e.Consequence)
+		clone.Alternative = foldBlock(e.Alternative)
+		return &clone
+
+	case *ast.FunctionExpression:
+		clone := *e
+		clone.Body = foldBlock(e.Body)
+		return &clone
+
+	case *ast.CallExpression:
+		clone := *e
+		clone.Function = foldExpression(e.Function)
+		clone.Argument = foldExpressions(e.Argument)
+		return &clone
+
+	case *ast.ArrayLiteral:
+		clone := *e
+		clone.Elements = foldExpressions(e.Elements)
+		return &clone
+
+	case *ast.IndexExpression:
+		clone := *e
+		clone.Left = foldExpression(e.Left)
+		clone.Index = foldExpression(e.Index)
+		return &clone
+
+	case *ast.SliceExpression:
+		clone := *e
+		clone.Left = foldExpression(e.Left)
+		clone.Low = foldExpression(e.Low)
+		clone.High = foldExpression(e.High)
+		return &clone
+
+	case *ast.IndexAssignExpression:
+		clone := *e
+		target := *e.Target
+		target.Left = foldExpression(e.Target.Left)
+		target.Index = foldExpression(e.Target.Index)
+		clone.Target = &target
+		clone.Value = foldExpression(e.Value)
+		return &clone
+
+	case *ast.HashLiteral:
+		clone := *e
+		clone.Pairs = make(map[ast.Expression]ast.Expression, len(e.Pairs))
+		// Keys are left as written, the order pairs are evaluated in and which of two equal keys
+		// wins both follow the source text of the keys
+		for k, v := range e.Pairs {
+			clone.Pairs[k] = foldExpression(v)
+		}
+		return &clone
+
+	default:
+		return e
+	}
+}
+
+func foldExpressions(list []ast.Expression) []ast.Expression {
+	folded := make([]ast.Expression, len(list))
+	for i, e := range list {
+		folded[i] = foldExpression(e)
+	}
+	return folded
+}
+
+// Folds a prefix operator applied to a literal, returns nil if it can not be folded
+func foldPrefix(e *ast.PrefixExpression) ast.Expression {
+	switch e.Operator {
+	case "-":
+		switch right := e.Right.(type) {
+		case *ast.IntegerLiteral:
+			if right.Value == math.MinInt64 {
+				return nil
+			}
+			return integerLiteral(e, -right.Value)
+		case *ast.FloatLiteral:
+			return floatLiteral(e, -right.Value)
+		}
+	case "~":
+		if right, ok := e.Right.(*ast.IntegerLiteral); ok {
+			return integerLiteral(e, ^right.Value)
+		}
+	case "!":
+		// Like OpBang, everything except false is truthy as literals are never null
+		switch right := e.Right.(type) {
+		case *ast.BoolExpression:
+			return boolLiteral(e, !right.Value)
+		case *ast.IntegerLiteral, *ast.BigIntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+			return boolLiteral(e, false)
+		}
+	}
+	return nil
+}
+
+// Folds an infix operator applied to two literals, returns nil if it can not be folded.
+// Anything which would fail at run time, like division by zero or mixing types, is left
+// alone so that the error is still raised.
+func foldInfix(e *ast.InfixExpression) ast.Expression {
+	if e.Operator == "&&" || e.Operator == "||" {
+		return foldLogical(e)
+	}
+	if left, right, ok := floatLiterals(e); ok {
+		return foldFloat(e, left, right)
+	}
+
+	switch left := e.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := e.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		switch e.Operator {
+		case "+", "-", "*", "/", "%", "&", "|", "^", "<<", ">>":
+			// Results which overflow are left to the VM, which either promotes them or raises
+			// an error depending on how it is configured
+			value, ok := object.Int64Operation(e.Operator, left.Value, right.Value)
+			if !ok {
+				return nil
+			}
+			return integerLiteral(e, value)
+		case "<":
+			return boolLiteral(e, left.Value < right.Value)
+		case ">":
+			return boolLiteral(e, left.Value > right.Value)
+		case "<=":
+			return boolLiteral(e, left.Value <= right.Value)
+		case ">=":
+			return boolLiteral(e, left.Value >= right.Value)
+		case "==":
+			return boolLiteral(e, left.Value == right.Value)
+		case "!=":
+			return boolLiteral(e, left.Value != right.Value)
+		}
+
+	case *ast.StringLiteral:
+		right, ok := e.Right.(*ast.StringLiteral)
+		if !ok {
+			return nil
+		}
+		switch e.Operator {
+		case "+":
+			return stringLiteral(e, left.Value+right.Value)
+		case "==":
+			return boolLiteral(e, left.Value == right.Value)
+		case "!=":
+			return boolLiteral(e, left.Value != right.Value)
+		case "<":
+			return boolLiteral(e, left.Value < right.Value)
+		case ">":
+			return boolLiteral(e, left.Value > right.Value)
+		case "<=":
+			return boolLiteral(e, left.Value <= right.Value)
+		case ">=":
+			return boolLiteral(e, left.Value >= right.Value)
+		}
+
+	case *ast.BoolExpression:
+		right, ok := e.Right.(*ast.BoolExpression)
+		if !ok {
+			return nil
+		}
+		switch e.Operator {
+		case "==":
+			return boolLiteral(e, left.Value == right.Value)
+		case "!=":
+			return boolLiteral(e, left.Value != right.Value)
+		}
+	}
+
+	return nil
+}
+
+// Folds && and || once the left literal decides the result, the right operand is never
+// evaluated then, or once both operands are literals
+func foldLogical(e *ast.InfixExpression) ast.Expression {
+	left, ok := literalTruthiness(e.Left)
+	if !ok {
+		return nil
+	}
+	if left == (e.Operator == "||") {
+		return boolLiteral(e, left)
+	}
+
+	right, ok := literalTruthiness(e.Right)
+	if !ok {
+		return nil
+	}
+	return boolLiteral(e, right)
+}
+
+// Values of two number literals of which at least one is a float
+func floatLiterals(e *ast.InfixExpression) (float64, float64, bool) {
+	_, leftFloat := e.Left.(*ast.FloatLiteral)
+	_, rightFloat := e.Right.(*ast.FloatLiteral)
+	if !leftFloat && !rightFloat {
+		return 0, 0, false
+	}
+	left, ok := numberLiteral(e.Left)
+	if !ok {
+		return 0, 0, false
+	}
+	right, ok := numberLiteral(e.Right)
+	return left, right, ok
+}
+
+func numberLiteral(e ast.Expression) (float64, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return float64(e.Value), true
+	case *ast.FloatLiteral:
+		return e.Value, true
+	default:
+		return 0, false
+	}
+}
+
+// Float arithmetic never fails, division by zero gives an infinity like at run time
+func foldFloat(e *ast.InfixExpression, left, right float64) ast.Expression {
+	switch e.Operator {
+	case "+":
+		return floatLiteral(e, left+right)
+	case "-":
+		return floatLiteral(e, left-right)
+	case "*":
+		return floatLiteral(e, left*right)
+	case "/":
+		return floatLiteral(e, left/right)
+	case "%":
+		return floatLiteral(e, math.Mod(left, right))
+	case "<":
+		return boolLiteral(e, left < right)
+	case ">":
+		return boolLiteral(e, left > right)
+	case "<=":
+		return boolLiteral(e, left <= right)
+	case ">=":
+		return boolLiteral(e, left >= right)
+	case "==":
+		return boolLiteral(e, left == right)
+	case "!=":
+		return boolLiteral(e, left != right)
+	}
+	return nil
+}
+
+// Reports whether a literal condition is truthy, ok is false if e is not a literal
+func literalTruthiness(e ast.Expression) (truthy bool, ok bool) {
+	switch e := e.(type) {
+	case *ast.BoolExpression:
+		return e.Value, true
+	case *ast.IntegerLiteral, *ast.BigIntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// Folded literals span the whole expression they replace
+func literalToken(original ast.Node, typ token.TokenType, literal string) token.Token {
+	return token.Token{Type: typ, Literal: literal, Pos: original.Pos(), End: original.End()}
+}
+
+func integerLiteral(original ast.Node, value int64) *ast.IntegerLiteral {
+	return &ast.IntegerLiteral{
+		Token: literalToken(original, token.INT, strconv.FormatInt(value, 10)),
+		Value: value,
+	}
+}
+
+func floatLiteral(original ast.Node, value float64) *ast.FloatLiteral {
+	return &ast.FloatLiteral{
+		Token: literalToken(original, token.FLOAT, strconv.FormatFloat(value, 'g', -1, 64)),
+		Value: value,
+	}
+}
+
+func stringLiteral(original ast.Node, value string) *ast.StringLiteral {
+	return &ast.StringLiteral{Token: literalToken(original, token.STRING, value), Value: value}
+}
+
+func boolLiteral(original ast.Node, value bool) *ast.BoolExpression {
+	typ := token.TokenType(token.FALSE)
+	if value {
+		typ = token.TRUE
+	}
+	return &ast.BoolExpression{Token: literalToken(original, typ, strconv.FormatBool(value)), Value: value}
+}
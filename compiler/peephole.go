@@ -0,0 +1,174 @@
+package compiler
+
+import "github.com/ShivankSharma070/go-compiler/code"
+
+// Peephole optimization
+//
+// Once all code of a scope has been generated, short instruction sequences which do nothing
+// useful are rewritten:
+//
+//	OpJump to the next instruction         removed
+//	OpNull; OpPop                          removed, unless it ends the program
+//	OpTrue; OpJumpNotTruthy                removed, the jump is never taken
+//	OpFalse; OpJumpNotTruthy target        OpJump target
+//
+// A sequence is only rewritten if no jump lands in the middle of it, otherwise the value it
+// pops could come from another path. Jumps to a removed instruction are moved to the next
+// instruction which is kept. Rewriting can create new opportunities, e.g. a jump over a removed
+// sequence becomes a jump to the next instruction, so passes repeat until nothing changes.
+
+type peepholeInstruction struct {
+	op       code.Opcode
+	operands []int
+	offset   int
+	width    int
+	wide     bool // Has the OpWide prefix, which is kept so that offsets only ever shrink
+	deleted  bool
+}
+
+// Runs the peephole optimizer over the current scope, keeping its line table and record of
+// last emitted instructions in sync with the rewritten instructions
+func (c *Compiler) peephole() {
+	scope := &c.scope[c.scopeIndex]
+	scope.instructions, scope.lines = peephole(scope.instructions, scope.lines)
+
+	// Offsets of the last two instructions have changed, find them again
+	scope.lastInstruction = EmittedInstruction{}
+	scope.previousInstruction = EmittedInstruction{}
+	for offset := 0; offset < len(scope.instructions); {
+		op, _, width, err := code.ReadInstruction(scope.instructions, offset)
+		if err != nil {
+			break
+		}
+		scope.previousInstruction = scope.lastInstruction
+		scope.lastInstruction = EmittedInstruction{op: op, position: offset}
+		offset += width
+	}
+}
+
+func peephole(ins code.Instructions, lines code.LineTable) (code.Instructions, code.LineTable) {
+	// Jump targets can only be moved if they are on instruction boundaries
+	if code.Verify(ins) != nil {
+		return ins, lines
+	}
+
+	for {
+		decoded := decodeInstructions(ins)
+		if !rewriteInstructions(decoded) {
+			return ins, lines
+		}
+		ins, lines = encodeInstructions(decoded, len(ins), lines)
+	}
+}
+
+func decodeInstructions(ins code.Instructions) []*peepholeInstruction {
+	decoded := []*peepholeInstruction{}
+	for offset := 0; offset < len(ins); {
+		op, operands, width, _ := code.ReadInstruction(ins, offset)
+		wide := code.Opcode(ins[offset]) == code.OpWide
+		decoded = append(decoded, &peepholeInstruction{op: op, operands: operands, offset: offset, width: width, wide: wide})
+		offset += width
+	}
+	return decoded
+}
+
+// Marks instructions to delete or replace, reports whether anything changed
+func rewriteInstructions(decoded []*peepholeInstruction) bool {
+	targets := map[int]bool{}
+	for _, ins := range decoded {
+		if i, ok := code.JumpOperand(ins.op); ok {
+			targets[ins.operands[i]] = true
+		}
+	}
+
+	changed := false
+	for i := 0; i < len(decoded); i++ {
+		first := decoded[i]
+
+		if first.op == code.OpJump && first.operands[0] == first.offset+first.width {
+			first.deleted = true
+			changed = true
+			continue
+		}
+
+		if i+1 == len(decoded) || targets[decoded[i+1].offset] {
+			continue
+		}
+		second := decoded[i+1]
+
+		// The value popped by the last instruction is the result of the program, so a final
+		// OpNull; OpPop stays
+		lastPop := i+2 == len(decoded)
+
+		switch {
+		case first.op == code.OpNull && second.op == code.OpPop && !lastPop,
+			first.op == code.OpTrue && second.op == code.OpJumpNotTruthy:
+			first.deleted = true
+			second.deleted = true
+		case first.op == code.OpFalse && second.op == code.OpJumpNotTruthy:
+			first.op = code.OpJump
+			first.operands = second.operands
+			first.wide = second.wide
+			second.deleted = true
+		default:
+			continue
+		}
+		changed = true
+		i++
+	}
+
+	return changed
+}
+
+// Writes out instructions which were not deleted, moving jump targets and line table entries
+// to the new offsets
+func encodeInstructions(decoded []*peepholeInstruction, length int, lines code.LineTable) (code.Instructions, code.LineTable) {
+	// New offset of every old instruction offset. A deleted instruction maps to the offset of
+	// the next instruction which is kept.
+	newOffsets := make(map[int]int, len(decoded)+1)
+	offset := 0
+	for _, ins := range decoded {
+		newOffsets[ins.offset] = offset
+		if !ins.deleted {
+			offset += len(ins.encode(ins.operands))
+		}
+	}
+	newOffsets[length] = offset
+
+	out := code.Instructions{}
+	for _, ins := range decoded {
+		if ins.deleted {
+			continue
+		}
+		operands := append([]int{}, ins.operands...)
+		if i, ok := code.JumpOperand(ins.op); ok {
+			operands[i] = newOffsets[operands[i]]
+		}
+		out = append(out, ins.encode(operands)...)
+	}
+
+	var newLines code.LineTable
+	for _, entry := range lines {
+		entryOffset, ok := newOffsets[entry.Offset]
+		if !ok || entryOffset >= len(out) {
+			continue
+		}
+		// An entry of a deleted instruction lands on the same offset as the next one, which
+		// then takes precedence
+		if n := len(newLines); n > 0 && newLines[n-1].Offset == entryOffset {
+			newLines = newLines[:n-1]
+		}
+		newLines = newLines.Add(entryOffset, entry.Pos)
+	}
+
+	return out, newLines
+}
+
+// Jump targets only move backwards, so an instruction without the prefix never needs it after
+// its operands are remapped and the width computed from the old operands stays right
+func (ins *peepholeInstruction) encode(operands []int) code.Instructions {
+	if ins.wide {
+		return code.MakeWide(ins.op, operands...)
+	}
+	return code.Make(ins.op, operands...)
+}
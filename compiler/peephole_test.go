@@ -0,0 +1,205 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/ShivankSharma070/go-compiler/code"
+	"github.com/ShivankSharma070/go-compiler/token"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []code.Instructions
+		expected []code.Instructions
+	}{
+		{
+			"jump to next instruction",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 6),
+				code.Make(code.OpPop),
+			},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"null then pop, jumps to it move to the next instruction",
+			[]code.Instructions{
+				code.Make(code.OpTrue),             // 0000
+				code.Make(code.OpJumpNotTruthy, 7), // 0001
+				code.Make(code.OpConstant, 0),      // 0004
+				code.Make(code.OpNull),             // 0007
+				code.Make(code.OpPop),              // 0008
+				code.Make(code.OpPop),              // 0009
+				code.Make(code.OpNull),             // 0010
+				code.Make(code.OpPop),              // 0011
+			},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"null then pop which is a jump target is kept",
+			[]code.Instructions{
+				code.Make(code.OpGetGlobal, 0),      // 0000
+				code.Make(code.OpJumpNotTruthy, 12), // 0003
+				code.Make(code.OpConstant, 0),       // 0006
+				code.Make(code.OpJump, 13),          // 0009
+				code.Make(code.OpNull),              // 0012
+				code.Make(code.OpPop),               // 0013
+			},
+			[]code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 12),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 13),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"false then jump not truthy becomes a jump",
+			[]code.Instructions{
+				code.Make(code.OpFalse),             // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 11),          // 0007
+				code.Make(code.OpNull),              // 0010
+				code.Make(code.OpPop),               // 0011
+			},
+			[]code.Instructions{
+				code.Make(code.OpJump, 9),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 10),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"jump to the end",
+			[]code.Instructions{
+				code.Make(code.OpJump, 8),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+			},
+			[]code.Instructions{
+				code.Make(code.OpJump, 6),
+				code.Make(code.OpConstant, 0),
+			},
+		},
+		{
+			"malformed instructions are left alone",
+			[]code.Instructions{
+				code.Make(code.OpJump, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			[]code.Instructions{
+				code.Make(code.OpJump, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		actual, _ := peephole(concatInstructions(tt.input), nil)
+		err := testInstructions(tt.expected, actual)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+	}
+}
+
+func TestPeepholeLineTable(t *testing.T) {
+	pos := func(line int) token.Position { return token.Position{Line: line, Column: 1} }
+
+	ins := concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0), // 0000 line 1
+		code.Make(code.OpNull),        // 0003 line 2
+		code.Make(code.OpPop),         // 0004
+		code.Make(code.OpConstant, 0), // 0005 line 3
+		code.Make(code.OpPop),         // 0008
+	})
+	lines := code.LineTable{
+		{Offset: 0, Pos: pos(1)},
+		{Offset: 3, Pos: pos(2)},
+		{Offset: 5, Pos: pos(3)},
+	}
+
+	_, actual := peephole(ins, lines)
+	expected := code.LineTable{
+		{Offset: 0, Pos: pos(1)},
+		{Offset: 3, Pos: pos(3)},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("wrong line table. want=%+v, got=%+v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("wrong entry %d. want=%+v, got=%+v", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestPeepholeKeepsLastInstruction(t *testing.T) {
+	compiler := New()
+	compiler.SetOptimizationLevel(OptimizePeephole)
+	err := compiler.Compile(parse(`if (false) { 1 }; 2`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	scope := compiler.scope[compiler.scopeIndex]
+	if scope.lastInstruction != (EmittedInstruction{op: code.OpPop, position: 3}) {
+		t.Errorf("wrong lastInstruction: %+v", scope.lastInstruction)
+	}
+	if scope.previousInstruction != (EmittedInstruction{op: code.OpConstant, position: 0}) {
+		t.Errorf("wrong previousInstruction: %+v", scope.previousInstruction)
+	}
+
+	// Removing the last pop must still leave a valid program behind
+	compiler.removeLastPop()
+	err = testInstructions([]code.Instructions{code.Make(code.OpConstant, 0)}, compiler.currentInstructions())
+	if err != nil {
+		t.Errorf("removeLastPop after peephole: %s", err)
+	}
+}
+
+func TestPeepholeInFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn() { if (false) { 1 }; 2 }`,
+			expectedConstants: []any{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+			optimization: OptimizePeephole,
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
@@ -0,0 +1,362 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/ShivankSharma070/go-compiler/code"
+	"github.com/ShivankSharma070/go-compiler/object"
+	"github.com/ShivankSharma070/go-compiler/token"
+)
+
+// Bytecode file format
+//
+// A bytecode file (.mbc) stores a compiled program, so it can be run without parsing and
+// compiling the source again. All integers are big endian, same as instruction operands.
+//
+//	magic      4 bytes   "MKBC"
+//	version    uint16    FormatVersion, files with any other version are rejected
+//	hash       uint64    InstructionSetHash() of the compiler which wrote the file
+//	files      uint32 count, then count strings, file names referenced by line tables
+//	globals    uint32 count, then count strings, names of global variables by index
+//	main       function  top level instructions
+//	constants  uint32 count, then count constants
+//
+// A string is a uint32 byte length followed by the bytes. A function is
+//
+//	name            string
+//	num locals      uint32
+//	num parameters  uint32
+//	instructions    uint32 length followed by the instructions
+//	line table      uint32 count, then for each entry the instruction offset, index into
+//	                files, byte offset, line and column, each as uint32
+//	local names     uint32 count, then count strings
+//	free names      uint32 count, then count strings
+//
+// A constant is a one byte tag followed by its value
+//
+//	1  integer            int64
+//	2  string             string
+//	3  compiled function  function
+//	4  float              IEEE 754 bits as uint64
+//	5  big integer        string, the value in decimal
+const (
+	BytecodeMagic = "MKBC"
+	FormatVersion = 4
+)
+
+const (
+	tagInteger          byte = 1
+	tagString           byte = 2
+	tagCompiledFunction byte = 3
+	tagFloat            byte = 4
+	tagBigInteger       byte = 5
+)
+
+var ErrTruncatedBytecode = errors.New("bytecode file is truncated")
+
+// InstructionSetHash fingerprints everything compiled bytecode depends on: opcodes with their
+// operand widths and the order of builtin functions, which OpGetBuiltin refers to by index.
+func InstructionSetHash() uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, code.DefinitionsHash())
+	for _, b := range object.Builtins {
+		fmt.Fprintf(h, "%s;", b.Name)
+	}
+	return h.Sum64()
+}
+
+// IsBytecodeFile reports whether data starts with the bytecode magic bytes
+func IsBytecodeFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(BytecodeMagic))
+}
+
+// WriteBytecode serializes bc to w in the bytecode file format
+func WriteBytecode(w io.Writer, bc *Bytecode) error {
+	enc := &encoder{files: map[string]uint32{}}
+
+	// File names are written up front, collect them from every line table first
+	enc.collectFiles(bc.Lines)
+	for _, constant := range bc.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			enc.collectFiles(fn.Lines)
+		}
+	}
+
+	enc.buf.WriteString(BytecodeMagic)
+	enc.uint16(FormatVersion)
+	enc.uint64(InstructionSetHash())
+
+	enc.uint32(len(enc.fileNames))
+	for _, name := range enc.fileNames {
+		enc.string(name)
+	}
+
+	enc.strings(bc.GlobalNames)
+
+	enc.function(&object.CompiledFunction{Instructions: bc.Instructions, Lines: bc.Lines})
+
+	enc.uint32(len(bc.Constants))
+	for i, constant := range bc.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			enc.buf.WriteByte(tagInteger)
+			enc.uint64(uint64(constant.Value))
+		case *object.Float:
+			enc.buf.WriteByte(tagFloat)
+			enc.uint64(math.Float64bits(constant.Value))
+		case *object.BigInteger:
+			enc.buf.WriteByte(tagBigInteger)
+			enc.string(constant.Value.String())
+		case *object.String:
+			enc.buf.WriteByte(tagString)
+			enc.string(constant.Value)
+		case *object.CompiledFunction:
+			enc.buf.WriteByte(tagCompiledFunction)
+			enc.function(constant)
+		default:
+			return fmt.Errorf("cannot serialize constant %d of type %s", i, constant.Type())
+		}
+	}
+
+	_, err := w.Write(enc.buf.Bytes())
+	return err
+}
+
+// ReadBytecode deserializes a program written by WriteBytecode. Files written by a different
+// format version or against a different instruction set are rejected.
+func ReadBytecode(r io.Reader) (*Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsBytecodeFile(data) {
+		return nil, fmt.Errorf("not a bytecode file: bad magic bytes")
+	}
+	dec := &decoder{data: data, pos: len(BytecodeMagic)}
+
+	version := dec.uint16()
+	if dec.err == nil && version != FormatVersion {
+		return nil, fmt.Errorf("incompatible bytecode: format version %d, expected %d", version, FormatVersion)
+	}
+	hash := dec.uint64()
+	if dec.err == nil && hash != InstructionSetHash() {
+		return nil, fmt.Errorf("incompatible bytecode: built for a different instruction set")
+	}
+
+	numFiles := dec.count()
+	for i := 0; i < numFiles && dec.err == nil; i++ {
+		dec.files = append(dec.files, dec.string())
+	}
+
+	globalNames := dec.strings()
+	main := dec.function()
+
+	numConstants := dec.count()
+	constants := make([]object.Object, 0, numConstants)
+	for i := 0; i < numConstants && dec.err == nil; i++ {
+		switch tag := dec.byte(); tag {
+		case tagInteger:
+			constants = append(constants, &object.Integer{Value: int64(dec.uint64())})
+		case tagFloat:
+			constants = append(constants, &object.Float{Value: math.Float64frombits(dec.uint64())})
+		case tagBigInteger:
+			text := dec.string()
+			value, ok := new(big.Int).SetString(text, 10)
+			if !ok && dec.err == nil {
+				dec.fail(fmt.Errorf("malformed big integer %q for constant %d", text, i))
+			}
+			constants = append(constants, &object.BigInteger{Value: value})
+		case tagString:
+			constants = append(constants, &object.String{Value: dec.string()})
+		case tagCompiledFunction:
+			constants = append(constants, dec.function())
+		default:
+			dec.fail(fmt.Errorf("unknown constant tag %d for constant %d", tag, i))
+		}
+	}
+
+	if dec.err == nil && dec.pos != len(dec.data) {
+		dec.fail(fmt.Errorf("unexpected %d bytes after the constant pool", len(dec.data)-dec.pos))
+	}
+	if dec.err != nil {
+		return nil, dec.err
+	}
+
+	return &Bytecode{
+		Instructions: main.Instructions,
+		Constants:    constants,
+		Lines:        main.Lines,
+		GlobalNames:  globalNames,
+	}, nil
+}
+
+type encoder struct {
+	buf       bytes.Buffer
+	files     map[string]uint32
+	fileNames []string
+}
+
+func (e *encoder) collectFiles(lines code.LineTable) {
+	for _, entry := range lines {
+		if _, ok := e.files[entry.Pos.Filename]; !ok {
+			e.files[entry.Pos.Filename] = uint32(len(e.fileNames))
+			e.fileNames = append(e.fileNames, entry.Pos.Filename)
+		}
+	}
+}
+
+func (e *encoder) uint16(v uint16) { binary.Write(&e.buf, binary.BigEndian, v) }
+func (e *encoder) uint32(v int)    { binary.Write(&e.buf, binary.BigEndian, uint32(v)) }
+func (e *encoder) uint64(v uint64) { binary.Write(&e.buf, binary.BigEndian, v) }
+
+func (e *encoder) string(s string) {
+	e.uint32(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) strings(list []string) {
+	e.uint32(len(list))
+	for _, s := range list {
+		e.string(s)
+	}
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.string(fn.Name)
+	e.uint32(fn.NumLocals)
+	e.uint32(fn.NumParameters)
+
+	e.uint32(len(fn.Instructions))
+	e.buf.Write(fn.Instructions)
+
+	e.uint32(len(fn.Lines))
+	for _, entry := range fn.Lines {
+		e.uint32(entry.Offset)
+		e.uint32(int(e.files[entry.Pos.Filename]))
+		e.uint32(entry.Pos.Offset)
+		e.uint32(entry.Pos.Line)
+		e.uint32(entry.Pos.Column)
+	}
+
+	e.strings(fn.LocalNames)
+	e.strings(fn.FreeNames)
+}
+
+// Reads from a byte slice with bounds checks. After the first error every read returns a zero
+// value, so callers only need to check err once they are done.
+type decoder struct {
+	data  []byte
+	pos   int
+	err   error
+	files []string
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data)-d.pos < n {
+		d.fail(ErrTruncatedBytecode)
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.read(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() int {
+	if b := d.read(4); b != nil {
+		return int(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.read(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// Reads an element count, rejecting counts that could not possibly fit in the remaining data
+func (d *decoder) count() int {
+	n := d.uint32()
+	if n > len(d.data)-d.pos {
+		d.fail(ErrTruncatedBytecode)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) string() string {
+	return string(d.read(d.uint32()))
+}
+
+func (d *decoder) strings() []string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	list := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		list = append(list, d.string())
+	}
+	return list
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{}
+	fn.Name = d.string()
+	fn.NumLocals = d.uint32()
+	fn.NumParameters = d.uint32()
+	fn.Instructions = code.Instructions(bytes.Clone(d.read(d.uint32())))
+
+	numLines := d.count()
+	for i := 0; i < numLines && d.err == nil; i++ {
+		offset := d.uint32()
+		file := d.uint32()
+		pos := token.Position{Offset: d.uint32(), Line: d.uint32(), Column: d.uint32()}
+		if d.err != nil {
+			break
+		}
+		if file >= len(d.files) {
+			d.fail(fmt.Errorf("line table refers to unknown file %d", file))
+			break
+		}
+		pos.Filename = d.files[file]
+		fn.Lines = append(fn.Lines, code.LineEntry{Offset: offset, Pos: pos})
+	}
+
+	fn.LocalNames = d.strings()
+	fn.FreeNames = d.strings()
+
+	return fn
+}
@@ -0,0 +1,111 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ShivankSharma070/go-compiler/lexer"
+	"github.com/ShivankSharma070/go-compiler/object"
+	"github.com/ShivankSharma070/go-compiler/parser"
+)
+
+func compileForSerialization(t *testing.T, input string) *Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.NewWithFilename("prog.mk", input))
+	program := p.ParseProgram()
+	comp := New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	input := `
+	let greeting = "hello";
+	let newAdder = fn(a) {
+		fn(b) { a + b + 1000000000000 }
+	};
+	let addTwo = newAdder(2);
+	puts(greeting, addTwo(-3), 0.25, 123456789012345678901234567890);
+	`
+	bytecode := compileForSerialization(t, input)
+
+	var buf bytes.Buffer
+	err := WriteBytecode(&buf, bytecode)
+	if err != nil {
+		t.Fatalf("WriteBytecode error: %s", err)
+	}
+	if !IsBytecodeFile(buf.Bytes()) {
+		t.Fatalf("written data does not start with magic bytes")
+	}
+
+	decoded, err := ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode error: %s", err)
+	}
+
+	if !bytes.Equal(decoded.Instructions, bytecode.Instructions) {
+		t.Errorf("wrong instructions.\nwant=%s\ngot=%s", bytecode.Instructions, decoded.Instructions)
+	}
+	if !reflect.DeepEqual(decoded.Lines, bytecode.Lines) {
+		t.Errorf("wrong line table.\nwant=%+v\ngot=%+v", bytecode.Lines, decoded.Lines)
+	}
+	if len(decoded.Constants) != len(bytecode.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(bytecode.Constants), len(decoded.Constants))
+	}
+	for i, want := range bytecode.Constants {
+		if !reflect.DeepEqual(decoded.Constants[i], want) {
+			t.Errorf("constant %d differs.\nwant=%+v\ngot=%+v", i, want, decoded.Constants[i])
+		}
+	}
+
+	inner := decoded.Constants[2].(*object.CompiledFunction)
+	if inner.NumParameters != 1 || inner.Lines.PositionFor(0).Filename != "prog.mk" {
+		t.Errorf("function metadata lost, got %+v", inner)
+	}
+}
+
+func TestReadBytecodeRejectsBadInput(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteBytecode(&buf, compileForSerialization(t, `let f = fn(x) { x * 2 }; f("a")`))
+	if err != nil {
+		t.Fatalf("WriteBytecode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	withVersion := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(withVersion[4:], FormatVersion+1)
+
+	withHash := bytes.Clone(valid)
+	withHash[6] ^= 0xff
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"bad magic", []byte("MONKEY"), "not a bytecode file"},
+		{"other version", withVersion, fmt.Sprintf("incompatible bytecode: format version %d", FormatVersion+1)},
+		{"other instruction set", withHash, "incompatible bytecode: built for a different instruction set"},
+		{"truncated", valid[:len(valid)-3], ErrTruncatedBytecode.Error()},
+		{"trailing data", append(bytes.Clone(valid), 0), "unexpected 1 bytes after the constant pool"},
+	}
+
+	for _, tt := range tests {
+		_, err := ReadBytecode(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
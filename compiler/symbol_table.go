@@ -14,6 +14,11 @@ type Symbol struct {
 	Name  string
 	Scope SymbolScope
 	Index int
+	Cell  bool // The variable holds a cell, see cells.go
+
+	// A global defined inside of a block, e.g. the body of a loop. Closures capture it like a
+	// local, since it is a different variable every time the block runs.
+	InBlock bool
 }
 
 type SymbolTable struct {
@@ -21,6 +26,12 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinations int
 	FreeSymbols    []Symbol
+	names          []string // Names passed to Define, indexed by symbol index
+	cells          map[string]bool // Locals which are defined as cells
+
+	// For every open block, the bindings its definitions shadow, nil for names which were
+	// not defined before the block
+	blocks []map[string]*Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -39,15 +50,57 @@ func (s *SymbolTable) Define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinations, Scope: GlobalScope}
 	if s.outer == nil {
 		symbol.Scope = GlobalScope
+		symbol.InBlock = len(s.blocks) > 0
+		symbol.Cell = symbol.InBlock && s.cells[name]
 	} else {
 		symbol.Scope = LocalScope
+		symbol.Cell = s.cells[name]
+	}
+
+	if n := len(s.blocks); n > 0 {
+		if _, ok := s.blocks[n-1][name]; !ok {
+			s.blocks[n-1][name] = s.binding(name)
+		}
 	}
 
 	s.store[name] = symbol
+	s.names = append(s.names, name)
 	s.numDefinations++
 	return symbol
 }
 
+// Opens a block, names defined inside of it are only visible till closeBlock. Their slots are
+// not reused, a block only limits where its names can be resolved.
+func (s *SymbolTable) openBlock() {
+	s.blocks = append(s.blocks, map[string]*Symbol{})
+}
+
+func (s *SymbolTable) closeBlock() {
+	block := s.blocks[len(s.blocks)-1]
+	s.blocks = s.blocks[:len(s.blocks)-1]
+	for name, previous := range block {
+		if previous == nil {
+			delete(s.store, name)
+		} else {
+			s.store[name] = *previous
+		}
+	}
+}
+
+// Symbol name is bound to in this table, nil if it has none
+func (s *SymbolTable) binding(name string) *Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return &symbol
+	}
+	return nil
+}
+
+// DefinedNames returns names of the globals or locals defined in this table, in index order.
+// A name defined twice appears at both indices.
+func (s *SymbolTable) DefinedNames() []string {
+	return append([]string(nil), s.names...)
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.outer != nil {
@@ -59,7 +112,7 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 		}
 
 		// If resolved
-		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+		if obj.Scope == GlobalScope && !obj.InBlock || obj.Scope == BuiltinScope {
 			return obj, ok
 		}
 
@@ -78,7 +131,7 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
-	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols)- 1, Scope:FreeScope}
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols)- 1, Scope:FreeScope, Cell: original.Cell}
 	s.store[original.Name] = symbol
 	return symbol
 }
@@ -89,3 +142,25 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	return symbol
 }
 
+
+// Definitions of a symbol table at some point, see snapshot
+type symbolTableState struct {
+	store          map[string]Symbol
+	numDefinations int
+	names          []string
+}
+
+// Records the symbols defined so far, restore forgets everything defined since
+func (s *SymbolTable) snapshot() symbolTableState {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+	return symbolTableState{store: store, numDefinations: s.numDefinations, names: s.names}
+}
+
+func (s *SymbolTable) restore(state symbolTableState) {
+	s.store = state.store
+	s.numDefinations = state.numDefinations
+	s.names = state.names[:len(state.names):len(state.names)]
+}
@@ -307,3 +307,77 @@ func TestShadowingFunctionName(t *testing.T) {
 		t.Errorf("expected %s to resovle to %+v, got=%+v", expected.Name, expected, result)
 	}
 }
+
+func TestCellSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.cells = map[string]bool{"a": true}
+	global.Define("a")
+
+	outer := NewEnclosedSymbolTable(global)
+	outer.cells = map[string]bool{"b": true}
+	outer.Define("b")
+	outer.Define("c")
+
+	inner := NewEnclosedSymbolTable(outer)
+
+	expected := []Symbol{
+		// Globals never live in cells
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 0, Cell: true},
+		{Name: "c", Scope: FreeScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := inner.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if !outer.store["b"].Cell || outer.store["c"].Cell {
+		t.Errorf("wrong cells in outer scope: %+v", outer.store)
+	}
+}
+
+func TestBlockScopes(t *testing.T) {
+	global := NewSymbolTable()
+	global.cells = map[string]bool{"b": true}
+	global.Define("a")
+
+	global.openBlock()
+	global.Define("a")
+	global.Define("b")
+
+	inner := NewEnclosedSymbolTable(global)
+	expected := []Symbol{
+		{Name: "a", Scope: FreeScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 1, Cell: true},
+	}
+	for _, sym := range expected {
+		result, ok := inner.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	global.closeBlock()
+
+	a, ok := global.Resolve("a")
+	if want := (Symbol{Name: "a", Scope: GlobalScope, Index: 0}); !ok || a != want {
+		t.Errorf("expected a to resolve to %+v after the block, got=%+v", want, a)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("b is resolvable after the block")
+	}
+	if global.numDefinations != 3 {
+		t.Errorf("slots of the block were reused, numDefinations=%d", global.numDefinations)
+	}
+}
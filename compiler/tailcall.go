@@ -0,0 +1,48 @@
+package compiler
+
+import "github.com/ShivankSharma070/go-compiler/code"
+
+// Turns calls whose result is returned right away into tail calls, so the VM can run them in
+// the frame of the caller instead of pushing a new one. A call is in tail position if it is
+// followed by OpReturnValue, either directly or after jumps, which is how the last expression
+// of a function body, of both branches of an if in tail position and return statements are
+// compiled. OpCall and OpTailCall have the same width, so no offsets change, and only the opcode
+// is replaced, after the OpWide prefix if there is one.
+func (c *Compiler) markTailCalls() {
+	ins := c.currentInstructions()
+
+	for offset := 0; offset < len(ins); {
+		op, _, width, err := code.ReadInstruction(ins, offset)
+		if err != nil {
+			return
+		}
+		if op == code.OpCall && returnsImmediately(ins, offset+width) {
+			if code.Opcode(ins[offset]) == code.OpWide {
+				ins[offset+1] = byte(code.OpTailCall)
+			} else {
+				ins[offset] = byte(code.OpTailCall)
+			}
+		}
+		offset += width
+	}
+}
+
+// Reports whether execution starting at offset reaches OpReturnValue without doing anything else
+func returnsImmediately(ins code.Instructions, offset int) bool {
+	// Bounded, so a cycle of jumps can not hang the compiler
+	for steps := 0; steps < len(ins) && offset < len(ins); steps++ {
+		op, operands, _, err := code.ReadInstruction(ins, offset)
+		if err != nil {
+			return false
+		}
+		switch op {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			offset = operands[0]
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package compiler
+
+import (
+	"errors"
+
+	"github.com/ShivankSharma070/go-compiler/code"
+)
+
+// Wide operands
+//
+// Operands which do not fit in their normal width are encoded with the OpWide prefix, which
+// emit does on its own. Forward jumps are the exception: they are emitted before their target
+// is known and patched once it is, so their width can not change. When a patched target does
+// not fit in 2 bytes, the function, or the program for top level code, is compiled again from
+// scratch with every jump emitted wide.
+
+var errJumpOutOfRange = errors.New("jump target does not fit in a 2 byte operand")
+
+// What compiling a function or program adds to the compiler, so it can be undone for a retry
+type compilerState struct {
+	scopes         int
+	symbolTable    *SymbolTable
+	scope          CompilationScope
+	constants      int
+	reusedLiterals int
+}
+
+func (c *Compiler) saveState() compilerState {
+	return compilerState{
+		scopes:         len(c.scope),
+		symbolTable:    c.symbolTable,
+		scope:          c.scope[c.scopeIndex],
+		constants:      len(c.constants),
+		reusedLiterals: c.reusedLiterals,
+	}
+}
+
+// Instructions are only ever appended to, or patched after the saved length, so truncating
+// the current scope restores it
+func (c *Compiler) restoreState(state compilerState) {
+	c.scope = c.scope[:state.scopes]
+	c.scopeIndex = state.scopes - 1
+	c.symbolTable = state.symbolTable
+
+	scope := &c.scope[c.scopeIndex]
+	scope.instructions = scope.instructions[:len(state.scope.instructions)]
+	scope.lines = state.scope.lines
+	scope.lastInstruction = state.scope.lastInstruction
+	scope.previousInstruction = state.scope.previousInstruction
+	scope.loops = state.scope.loops
+	scope.pending = state.scope.pending
+
+	c.constants = c.constants[:state.constants]
+	c.reusedLiterals = state.reusedLiterals
+	c.internConstants()
+}
+
+// Emits a jump with a placeholder target, to be filled in by changeOperand. The target is the
+// first operand, operands are any others the instruction has.
+func (c *Compiler) emitJump(op code.Opcode, operands ...int) int {
+	operands = append([]int{9999}, operands...)
+	if c.scope[c.scopeIndex].wideJumps {
+		return c.emitInstruction(op, code.MakeWide(op, operands...))
+	}
+	return c.emitInstruction(op, code.Make(op, operands...))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ShivankSharma070/go-compiler/compiler"
+)
+
+// disasmCommand implements `disasm [-O level] [-compare] <file>`, printing the compiled form of
+// a source or bytecode file. Bytecode files are not verified first, so broken files can be
+// inspected too.
+func disasmCommand(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	optimization := optimizationFlag(flags)
+	compare := flags.Bool("compare", false, "print source files before and after peephole optimization")
+	files, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if !validOptimizationLevel(*optimization) {
+		return exitUsage
+	}
+
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "disasm expects exactly one file, got %d\n\n%s", len(files), usage)
+		return exitUsage
+	}
+
+	filename, data, err := readInput(files[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	var bytecode *compiler.Bytecode
+	if compiler.IsBytecodeFile(data) {
+		if *compare {
+			fmt.Fprintf(os.Stderr, "%s: -compare needs a source file\n", filename)
+			return exitUsage
+		}
+		bytecode, err = compiler.ReadBytecode(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+			return exitError
+		}
+	} else {
+		program, ok := parseSource(filename, string(data))
+		if !ok {
+			return exitError
+		}
+		comp, ok := compileProgram(filename, program, *optimization)
+		if !ok {
+			return exitError
+		}
+		bytecode = comp.Bytecode()
+
+		if *compare {
+			// Compiling again without the peephole stage gives exactly the same constants
+			before, _ := compileProgram(filename, program, min(*optimization, int(compiler.OptimizeFold)))
+			fmt.Printf("==== before peephole optimization ====\n%s\n", compiler.Disassemble(before.Bytecode()))
+			fmt.Print("==== after peephole optimization ====\n")
+		}
+	}
+
+	fmt.Print(compiler.Disassemble(bytecode))
+	return exitOK
+}
@@ -12,4 +12,7 @@ var builtins = map[string]*object.Builtin{
 	"rest": object.GetBuiltinByName("rest"),
 	"push": object.GetBuiltinByName("push"),
 	"puts":object.GetBuiltinByName("puts") ,
+	"int":   object.GetBuiltinByName("int"),
+	"float": object.GetBuiltinByName("float"),
+	"bytelen": object.GetBuiltinByName("bytelen"),
 }
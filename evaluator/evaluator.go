@@ -2,16 +2,25 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+
 	"github.com/ShivankSharma070/go-compiler/ast"
 	"github.com/ShivankSharma070/go-compiler/object"
+	"github.com/ShivankSharma070/go-compiler/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// Maximum depth of nested function calls, the same as the vm allows by default. Deeper
+// recursion is reported as an error instead of overflowing the Go stack.
+const MaxCallDepth = 1024
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
@@ -20,6 +29,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntegerLiteral:
+		return &object.BigInteger{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.BoolExpression:
@@ -28,98 +41,130 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return withPosition(evalPrefixExpression(node.Operator, right), node.Token.Pos)
 	case *ast.InfixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
-			return right
-		}
+		// Left to right like the VM, which matters once operands can assign to variables
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
-		return evalInfixExpression(node.Operator, right, left)
+		if isLogicalOperator(node.Operator) {
+			return evalLogicalExpression(node.Operator, left, node.Right, env)
+		}
+		right := Eval(node.Right, env)
+		if isAbrupt(right) {
+			return right
+		}
+		return withPosition(evalInfixExpression(node.Operator, right, left), node.Token.Pos)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node.Statements, env)
 	case *ast.IfElseExpression:
 		return evalIfExpression(node, env)
 	case *ast.ReturnStatement:
 		value := Eval(node.ReturnValue, env)
-		if isError(value) {
+		if isAbrupt(value) {
 			return value
 		}
 		return &object.ReturnValue{Value: value}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.AssignExpression:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return withPosition(evalAssignment(node.Name, val, env), node.Token.Pos)
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return withPosition(evalIdentifier(node, env), node.Pos())
 	case *ast.FunctionExpression:
 		params := node.Parameters
 		body := node.Body
 		return &object.FunctionLiteral{Parameters: params, Body: body, Env: env}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 		args := evalExpressions(node.Argument, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return withPosition(applyFunction(function, args, env), node.Pos())
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return withPosition(evalIndexExpression(left, index), node.Token.Pos)
+	case *ast.SliceExpression:
+		return withPosition(evalSliceExpression(node, env), node.Token.Pos)
+	case *ast.IndexAssignExpression:
+		return withPosition(evalIndexAssignment(node, env), node.Token.Pos)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return withPosition(evalHashLiteral(node, env), node.Pos())
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	}
 	return nil
 }
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
+	hash := object.NewHash()
+	// Same order as the compiler, so side effects happen in the same order in both engines
+	for _, keyNode := range node.SortedKeys() {
+		valueNode := node.Pairs[keyNode]
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
+		if _, ok := key.(object.Hashable); !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
 		value := Eval(valueNode, env)
-		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+		if isAbrupt(value) {
+			return value
+		}
+		hash.Pairs.Set(key, value)
 	}
 
-	return &object.Hash{Pair: pairs}
+	return hash
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.ARRAY_OBJ:
+		return newError("array index must be INTEGER, got %s", index.Type())
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ:
+		return newError("string index must be INTEGER, got %s", index.Type())
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -127,14 +172,59 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// Bounds which are left out are nil, object.Slice takes them to be the start and the end
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+
+	var bounds [2]object.Object
+	for i, bound := range []ast.Expression{node.Low, node.High} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = Eval(bound, env)
+		if isAbrupt(bounds[i]) {
+			return bounds[i]
+		}
+	}
+
+	result, err := object.Slice(left, bounds[0], bounds[1])
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
+
+// Like an assignment to a variable, the value of the expression is the value assigned
+func evalIndexAssignment(node *ast.IndexAssignExpression, env *object.Environment) object.Object {
+	left := Eval(node.Target.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+	index := Eval(node.Target.Index, env)
+	if isAbrupt(index) {
+		return index
+	}
+	value := Eval(node.Value, env)
+	if isAbrupt(value) {
+		return value
+	}
+
+	if err := object.SetIndex(left, index, value); err != nil {
+		return newError("%s", err)
+	}
+	return value
+}
+
 func evalHashIndexExpression(left, index object.Object) object.Object {
 	hashObject := left.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
+	if _, ok := index.(object.Hashable); !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pair[key.HashKey()]
+	pair, ok := hashObject.Pairs.Get(index)
 	if !ok {
 		return NULL
 	}
@@ -144,10 +234,8 @@ func evalHashIndexExpression(left, index object.Object) object.Object {
 
 func evalArrayIndexExpression(left, index object.Object) object.Object {
 	arrayObj := left.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObj.Elements) - 1)
-
-	if idx < 0 || idx > max {
+	idx, ok := object.ElementIndex(index, len(arrayObj.Elements))
+	if !ok {
 		return NULL
 	}
 
@@ -155,11 +243,20 @@ func evalArrayIndexExpression(left, index object.Object) object.Object {
 
 }
 
+func evalStringIndexExpression(left, index object.Object) object.Object {
+	char, ok := object.CharAt(left.(*object.String).Value, index)
+	if !ok {
+		return NULL
+	}
+
+	return &object.String{Value: char}
+}
+
 func evalExpressions(args []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 	for _, e := range args {
 		evaluated := Eval(e, env)
-		if evaluated != nil && isError(evaluated) {
+		if evaluated != nil && isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -168,14 +265,22 @@ func evalExpressions(args []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// Calls fn from a function whose environment is env
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch fn := fn.(type) {
 	case *object.FunctionLiteral:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if env.Depth >= MaxCallDepth {
+			return newError("stack overflow: maximum call depth of %d exceeded", MaxCallDepth)
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
+		extendedEnv.Depth = env.Depth + 1
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+		if result := callBuiltin(fn, args); result != nil {
 			return result
 		}
 
@@ -186,6 +291,17 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 
 }
 
+// Calls a builtin, turning a Go panic inside it into an error object
+func callBuiltin(fn *object.Builtin, args []object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+
+	return fn.Fn(args...)
+}
+
 func extendFunctionEnv(fn *object.FunctionLiteral, args []object.Object) *object.Environment {
 	env := object.NewEnclosingEnvironment(fn.Env)
 	for paramIdx, name := range fn.Parameters {
@@ -213,9 +329,78 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	return newError("identifier not found: %s", node.Value)
 }
 
+func evalAssignment(name *ast.Identifier, value object.Object, env *object.Environment) object.Object {
+	if env.Assign(name.Value, value) {
+		return value
+	}
+
+	if _, ok := builtins[name.Value]; ok {
+		return newError("cannot assign to builtin %s", name.Value)
+	}
+	return newError("identifier not found: %s", name.Value)
+}
+
+// Every iteration runs the body in an environment of its own, so closures created by different
+// iterations do not share the variables defined inside of the loop
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(node.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := Eval(node.Body, object.NewEnclosingEnvironment(env))
+		if result, done := endsLoop(result); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	it, ok := object.NewIterator(iterable)
+	if !ok {
+		return withPosition(newError("cannot iterate over %s", iterable.Type()), node.Pos())
+	}
+
+	variables := node.Variables()
+	for {
+		values, ok := it.Next(len(variables))
+		if !ok {
+			return NULL
+		}
+
+		loopEnv := object.NewEnclosingEnvironment(env)
+		for i, v := range variables {
+			loopEnv.Set(v.Value, values[i])
+		}
+		result := Eval(node.Body, loopEnv)
+		if result, done := endsLoop(result); done {
+			return result
+		}
+	}
+}
+
+// Reports whether the result of a loop body ends the loop, and what the loop then gives
+func endsLoop(result object.Object) (object.Object, bool) {
+	switch result.(type) {
+	case *object.Break:
+		return NULL, true
+	case *object.ReturnValue, *object.Error:
+		return result, true
+	}
+	return nil, false
+}
+
 func evalIfExpression(node *ast.IfElseExpression, env *object.Environment) object.Object {
 	condition := Eval(node.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 
@@ -234,6 +419,11 @@ func evalPrefixExpression(operator string, value object.Object) object.Object {
 		return evalBangOperatorExpression(value)
 	case "-":
 		return evalMinusPrefixOperatorExpression(value)
+	case "~":
+		if value.Type() != object.INTEGER_OBJ {
+			return newError("unknown operator: ~%s", value.Type())
+		}
+		return object.NotInteger(value)
 	default:
 		return newError("unknown operator: %s%s", operator, value.Type())
 	}
@@ -241,12 +431,14 @@ func evalPrefixExpression(operator string, value object.Object) object.Object {
 
 // Evaluate expresions with minus as prefix operators
 func evalMinusPrefixOperatorExpression(value object.Object) object.Object {
+	if f, ok := value.(*object.Float); ok {
+		return &object.Float{Value: -f.Value}
+	}
 	if value.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", value.Type())
 	}
 
-	int_value := value.(*object.Integer).Value
-	return &object.Integer{Value: -int_value}
+	return object.NegateInteger(value)
 }
 
 // Evaluate bang prefix operations
@@ -265,66 +457,128 @@ func evalBangOperatorExpression(value object.Object) object.Object {
 }
 
 func evalInfixExpression(operator string, right, left object.Object) object.Object {
+	// Like in the VM any two values can be compared for equality, values of different types
+	// are never equal
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(object.Equal(left, right))
+	case "!=":
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	}
+
 	switch {
 	case right.Type() == object.INTEGER_OBJ && left.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, right, left)
 	case right.Type() == object.STRING_OBJ && left.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, right, left)
 	case right.Type() == object.BOOLEAN_OBJ && left.Type() == object.BOOLEAN_OBJ:
-		return evalBoolInfixExpression(operator, right, left)
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	case isNumber(left) && isNumber(right) && (left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ):
+		return evalFloatInfixExpression(operator, right, left)
 	case right.Type() != left.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unkown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalStringInfixExpression(operator string, right, left object.Object) object.Object {
-	leftValue := left.(*object.String).Value
-	rightValue := right.(*object.String).Value
+func isLogicalOperator(operator string) bool {
+	return operator == "&&" || operator == "||"
+}
+
+// && and || evaluate their right operand only if the left one does not decide the result.
+// The result is always a boolean.
+func evalLogicalExpression(operator string, left object.Object, right ast.Expression, env *object.Environment) object.Object {
+	if isTruthy(left) == (operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+
+	value := Eval(right, env)
+	if isAbrupt(value) {
+		return value
+	}
+	return nativeBoolToBooleanObject(isTruthy(value))
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func floatValue(obj object.Object) float64 {
+	if f, ok := obj.(*object.Float); ok {
+		return f.Value
+	}
+	return object.IntegerToFloat(obj)
+}
+
+// At least one operand is a float, the other one is converted. Like the VM, floats follow
+// IEEE 754, so dividing by zero is not an error.
+func evalFloatInfixExpression(operator string, right, left object.Object) object.Object {
+	leftVal := floatValue(left)
+	rightVal := floatValue(right)
 	switch operator {
 	case "+":
-		return &object.String{Value: leftValue + rightValue}
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalBoolInfixExpression(operator string, right, left object.Object) object.Object {
+func evalStringInfixExpression(operator string, right, left object.Object) object.Object {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+	// Strings are ordered lexicographically, byte by byte
 	switch operator {
-	case "==":
-		return nativeBoolToBooleanObject(right == left)
-	case "!=":
-		return nativeBoolToBooleanObject(right != left)
+	case "+":
+		return &object.String{Value: leftValue + rightValue}
+	case "<":
+		return nativeBoolToBooleanObject(leftValue < rightValue)
+	case ">":
+		return nativeBoolToBooleanObject(leftValue > rightValue)
+	case "<=":
+		return nativeBoolToBooleanObject(leftValue <= rightValue)
+	case ">=":
+		return nativeBoolToBooleanObject(leftValue >= rightValue)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// Integers never overflow, results which do not fit in an int64 become big integers
 func evalIntegerInfixExpression(operator string, right, left object.Object) object.Object {
-	rightVal := right.(*object.Integer).Value
-	leftVal := left.(*object.Integer).Value
+	cmp := object.CompareIntegers(left, right)
 	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
 	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
+		return nativeBoolToBooleanObject(cmp < 0)
 	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
-	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
-	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
-	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return nativeBoolToBooleanObject(cmp > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(cmp <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(cmp >= 0)
 	}
 
+	result, err := object.IntegerOperation(operator, left, right)
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
 }
 
 func evalBlockStatement(stmts []ast.Statement, env *object.Environment) object.Object {
@@ -333,12 +587,16 @@ func evalBlockStatement(stmts []ast.Statement, env *object.Environment) object.O
 	for _, stmt := range stmts {
 		result = Eval(stmt, env)
 		if result != nil {
-			if result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ {
+			if isAbrupt(result) {
 				return result
 			}
 		}
 	}
 
+	// A block without a value, e.g. one ending in a let statement, gives null like in the VM
+	if result == nil {
+		return NULL
+	}
 	return result
 }
 
@@ -378,13 +636,24 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+// Records pos on an error object which does not know where it was raised yet
+func withPosition(obj object.Object, pos token.Position) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	return obj
+}
+
 func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func isError(obj object.Object) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+// Reports whether obj stops evaluation of the enclosing expressions and statements, which
+// errors do, as well as return, break and continue inside of an if expression
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
 	}
 	return false
 }
@@ -29,6 +29,15 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"~5", -6},
+		{"1 + 2 << 3 | 4 & 5", 21},
 	}
 
 	for _, tt := range tests {
@@ -52,6 +61,70 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func TestEvalBigIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"123456789012345678901234567890 * 3", "370370367037037036703703703670"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"~(1 << 64)", "-18446744073709551617"},
+		{"(1 << 100) / (1 << 98)", "4"},
+		{"(1 << 64) > 9223372036854775807", "true"},
+		{"{1 << 64: \"big\"}[18446744073709551616]", "big"},
+		{"[1][1 << 64]", "NULL"},
+		{"int(1e20)", "100000000000000000000"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+	}
+
+	for _, tt := range tests {
+		output := testEval(tt.input)
+		if output.Inspect() != tt.expected {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.expected, output.Inspect())
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1", 2.5},
+		{"1 - 0.5", 0.5},
+		{"2 * 1.25", 2.5},
+		{"10 / 4.0", 2.5},
+		{"7.5 % 2", 1.5},
+		{"1e3 + 0.5", 1000.5},
+		{`float(3) / 2`, 1.5},
+		{`float("2.25")`, 2.25},
+	}
+
+	for _, tt := range tests {
+		output := testEval(tt.input)
+		testFloatObject(t, output, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	floatObj, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("Object not of type object.Float, got %T (%+v)", obj, obj)
+		return false
+	}
+
+	if floatObj.Value != expected {
+		t.Errorf("floatObj.value is not %g, got %g", expected, floatObj.Value)
+		return false
+	}
+
+	return true
+}
+
 // ======== BOOLEAN ==========
 func TestEvalBooleanExpresion(t *testing.T) {
 	tests := []struct {
@@ -68,6 +141,33 @@ func TestEvalBooleanExpresion(t *testing.T) {
 		{"1 != 1", false},
 		{"1 == 2", false},
 		{"1 != 2", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 >= 4", false},
+		{"4 >= 4", true},
+		{`"abc" < "abd"`, true},
+		{`"b" > "abc"`, true},
+		{`"a" <= "a"`, true},
+		{`"" >= "a"`, false},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"1 < 1.5", true},
+		{"2.5 >= 3", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{`"abc" == "ab" + "c"`, true},
+		{`let s = "x"; s + s != "xx"`, false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, 2] != [1, 2, 3]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"if (false) { 1 } == if (false) { 2 }", true},
+		{"if (false) { 1 } == false", false},
+		{`1 == "1"`, false},
+		{`[1] != {}`, true},
+		{"[1, 2.0] == [1.0, 2]", true},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
 	}
 
 	for _, tt := range tests {
@@ -110,6 +210,32 @@ func TestBangOperator(t *testing.T) {
 	}
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"0 || null", true},
+		{"if (false) { 1 } || false", false},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"false && undefined", false},
+		{"true || undefined", true},
+		{"let x = 0; true || (x = 1); false && (x = 2); x == 0", true},
+		{"let x = 0; false || (x = 1); true && (x = x + 1); x == 2", true},
+	}
+
+	for _, tt := range tests {
+		output := testEval(tt.input)
+		testBooleanObject(t, output, tt.expected)
+	}
+}
+
 // ================ Conditional Expressions ===========
 func TestIfElseExpression(t *testing.T) {
 	tests := []struct {
@@ -187,6 +313,70 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a = 6; a;", 6},
+		{"let a = 5; a = a + 1;", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let a = 1; let f = fn() { a = a + 1; }; f(); f(); a;", 3},
+		{"let a = 1; let f = fn(a) { a = 10; a }; f(1) + a;", 11},
+		{
+			`let counter = fn() { let n = 0; fn() { n = n + 1 } };
+			let c = counter(); let d = counter();
+			c(); c(); d(); c() * 10 + d()`,
+			32,
+		},
+		{
+			// Closures and the function defining them share the variable
+			`let f = fn() { let n = 1; let get = fn() { n }; n = 5; get() }; f()`,
+			5,
+		}, // Operands are evaluated left to right
+		{"let a = 1; let f = fn() { a = a * 10 }; f() + a", 20},
+		{"let a = [1, 2]; a[0] = 5; a[0] + a[1]", 7},
+		{"let a = [1, 2]; a[-1] = 5", 5},
+		{"let a = [1]; let b = a; b[0] = 3; a[0]", 3},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] = 10; h["a"] + h["b"]`, 12},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let i = 0; while (i < 5) { i = i + 1 }; i", 5},
+		{"let i = 0; while (true) { i = i + 1; if (i == 3) { break; } }; i", 3},
+		{"let i = 0; let s = 0; while (i < 5) { i = i + 1; if (i == 2) { continue; } s = s + i }; s", 13},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x }; s", 6},
+		{"let s = 0; for (i, x in [10, 20]) { s = s + i * x }; s", 20},
+		{`let s = 0; for (k, v in {"b": 1, "a": 2}) { s = s * 10 + v }; s`, 21},
+		{"let s = 0; for (x in [1, 2, 3]) { for (y in [1, 2, 3]) { if (y > x) { break; } s = s + 1 } }; s", 6},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + 10 * x + [x, if (x == 2) { continue; } else { x }][1] }; s", 44},
+		{"let find = fn(arr, v) { for (i, x in arr) { if (x == v) { return i; } } -1 }; find([5, 6], 6) * 10 + find([5], 6)", 9},
+		{"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }) }; fs[0]() * 10 + fs[2]()", 13},
+		{"let x = 5; for (x in [1]) { x = 9 }; x", 5},
+		{"let f = fn() { while (false) { } }; f()", nil},
+		{"while (false) { }", nil},
+		{"if (true) { let x = 1; }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 // ==================== STRING ==================
 func TestStringLiteral(t *testing.T) {
 	input := `"hello world";`
@@ -325,6 +515,14 @@ func TestArrayIndexExpression(t *testing.T) {
 		},
 		{
 			"[1, 2, 3][-1]",
+			3,
+		},
+		{
+			"[1, 2, 3][-3]",
+			1,
+		},
+		{
+			"[1, 2, 3][-4]",
 			nil,
 		},
 	}
@@ -340,6 +538,33 @@ func TestArrayIndexExpression(t *testing.T) {
 	}
 }
 
+func TestSliceAndStringIndexExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+		{"[1, 2, 3][:-1]", "[1, 2]"},
+		{"[1, 2, 3][-2:]", "[2, 3]"},
+		{"[1, 2, 3][2:1]", "[]"},
+		{"[1, 2, 3][-10:10]", "[1, 2, 3]"},
+		{"let a = [1, 2]; let b = a[:]; b[0] = 9; a", "[1, 2]"},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[-1]`, "o"},
+		{`"hello"[5]`, "NULL"},
+		{`"日本語"[1]`, "本"},
+		{`"héllo, 世界"[1:5]`, "éllo"},
+		{`let 名前 = "世界"; 名前[-1]`, "界"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 // =============== HASH ====================
 func TestHashLiteral(t *testing.T) {
 	input := `let two = "two";
@@ -367,12 +592,16 @@ func TestHashLiteral(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pair) != len(expected) {
-		t.Fatalf("Hash has wrong number of pairs, got %d", len(result.Pair))
+	if result.Pairs.Len() != len(expected) {
+		t.Fatalf("Hash has wrong number of pairs, got %d", result.Pairs.Len())
 	}
 
+	pairs := map[object.HashKey]object.HashPair{}
+	for _, pair := range result.Pairs.Pairs() {
+		pairs[pair.Key.(object.Hashable).HashKey()] = pair
+	}
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pair[expectedKey]
+		pair, ok := pairs[expectedKey]
 		if !ok {
 			t.Errorf("no pair for give hash key")
 		}
@@ -414,6 +643,9 @@ func TestHashIndexExpression(t *testing.T) {
 			`{true:5}[true]`,
 			5,
 		},
+		{`{1: 5}[1.0]`, 5},
+		{`{1: 5}[1.5]`, nil},
+		{`let h = {2: 1}; h[2.0] = 5; h[2]`, 5},
 	}
 
 	for _, tt := range tests {
@@ -436,8 +668,18 @@ func TestBuiltInFunction(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo, 世界")`, 9},
+		{`bytelen("héllo, 世界")`, 14},
+		{`bytelen(1)`, "argument to `bytelen` must be STRING, got INTEGER"},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`int(3.99)`, 3},
+		{`int(-3.99)`, -3},
+		{`int("42")`, 42},
+		{`int(7)`, 7},
+		{`int(0.0 / 0.0)`, "cannot convert NaN to INTEGER"},
+		{`int("4.5")`, `cannot convert "4.5" to INTEGER`},
+		{`float(true)`, "argument to `float` not supported, got BOOLEAN"},
 	}
 
 	for _, tt := range tests {
@@ -512,6 +754,28 @@ return 1;
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{"10 / 0", "division by zero"},
+		{"let f = fn(x) { 1 / x }; f(0) + 1", "division by zero"},
+		{`[1, 2, 3]["a"]`, "array index must be INTEGER, got STRING"},
+		{"5(1)", "not a function: INTEGER"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2, got=1"},
+		{`{"a": 1 / 0}`, "division by zero"},
+		{"x = 1", "identifier not found: x"},
+		{"len = 1", "cannot assign to builtin len"},
+		{"let x = 1; x = -true", "unknown operator: -BOOLEAN"},
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{"for (x in [1]) { let y = x; }; y", "identifier not found: y"},
+		{"while (true) { 1 + true }", "type mismatch: INTEGER + BOOLEAN"},
+		{"10 % 0", "division by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{`~"a"`, "unknown operator: ~STRING"},
+		{`"a" % "b"`, "unknown operator: STRING % STRING"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1 with length 1"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{`[1, 2]["a":]`, "slice index must be INTEGER, got STRING"},
+		{`"abc"["a"]`, "string index must be INTEGER, got STRING"},
 	}
 
 	for _, tt := range tests {
@@ -538,3 +802,58 @@ func testEval(input string) object.Object {
 	program := p.ParseProgram()
 	return Eval(program, env)
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\nlet b = a / 0;", "2:11"},
+		{"let f = fn() {\n  missing\n};\nf()", "2:3"},
+		{"[1][true]", "1:4"},
+		{"len(1)", "1:1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object returned, got %T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Pos.String() != tt.expected {
+			t.Errorf("wrong error position for %q. want=%s, got=%s", tt.input, tt.expected, errObj.Pos)
+		}
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let f = fn(x) { f(x) + 1 }; f(1)", "stack overflow: maximum call depth of 1024 exceeded"},
+		{"let f = fn(x) { let r = 0; while (true) { r = f(x) } }; f(1)", "stack overflow: maximum call depth of 1024 exceeded"},
+		{"let f = fn(x) { if (x == 0) { 0 } else { 1 + f(x - 1) } }; f(1023)", 1023},
+		{"let f = fn(x) { if (x == 0) { 0 } else { 1 + f(x - 1) } }; f(1024)", "stack overflow: maximum call depth of 1024 exceeded"},
+		{"let f = fn(x) { if (x == 0) { 0 } else { 1 + f(x - 1) } }; f(1000) + f(1000)", 2000},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("No error object returned for %q, got %T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. want=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
@@ -1,37 +1,77 @@
 package lexer
 
-import "github.com/ShivankSharma070/go-compiler/token"
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ShivankSharma070/go-compiler/token"
+)
 
 type Lexer struct {
 	input        string
-	position     int  // Position of current char
-	readPosition int  // Position of next char
-	ch           byte // Current Character
+	position     int  // Byte offset of current char
+	readPosition int  // Byte offset of next char
+	ch           rune // Current Character, decoded from UTF-8
+
+	filename string
+	line     int // Line of current char
+	column   int // Column of current char, counted in characters rather than bytes
 }
 
 func New(inp string) *Lexer {
+	return NewWithFilename("", inp)
+}
+
+// NewWithFilename creates a lexer whose token positions refer to the given file name
+func NewWithFilename(filename, inp string) *Lexer {
 	l := &Lexer{
-		input: inp,
+		input:    inp,
+		filename: filename,
+		line:     1,
 	}
 	l.ReadChar()
 	return l
 }
 
-func (l *Lexer) PeekChar() byte {
+func (l *Lexer) PeekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
 func (l *Lexer) ReadChar() {
+	// Once we are at the end of input, stay there so that EOF tokens keep a sane position
+	if l.position >= len(l.input) && l.readPosition > l.position {
+		return
+	}
+
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+
+	// Invalid UTF-8 decodes to utf8.RuneError one byte at a time
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
+	l.column++
+}
+
+// Position of the current character
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
 }
 
 func (l *Lexer) NextToken() token.Token {
@@ -39,6 +79,7 @@ func (l *Lexer) NextToken() token.Token {
 
 	// Eat all the whitespace as it does not matter in the language we are creating
 	l.eatWhitespaces()
+	start := l.currentPosition()
 
 	switch l.ch {
 	case '=':
@@ -65,10 +106,42 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	case '/':
 		tok = token.NewToken(token.SLASH, l.ch)
+	case '%':
+		tok = token.NewToken(token.PERCENT, l.ch)
+	case '&':
+		if l.PeekChar() == '&' {
+			tok = l.readTwoCharToken(token.AND)
+		} else {
+			tok = token.NewToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.PeekChar() == '|' {
+			tok = l.readTwoCharToken(token.OR)
+		} else {
+			tok = token.NewToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = token.NewToken(token.CARET, l.ch)
+	case '~':
+		tok = token.NewToken(token.TILDE, l.ch)
 	case '>':
-		tok = token.NewToken(token.GT, l.ch)
+		switch l.PeekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.readTwoCharToken(token.SHR)
+		default:
+			tok = token.NewToken(token.GT, l.ch)
+		}
 	case '<':
-		tok = token.NewToken(token.LT, l.ch)
+		switch l.PeekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.readTwoCharToken(token.SHL)
+		default:
+			tok = token.NewToken(token.LT, l.ch)
+		}
 	case ';':
 		tok = token.NewToken(token.SEMICOLON, l.ch)
 	case ':':
@@ -97,24 +170,33 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdenOrLiteral(isLetter)
 			tok.Type = token.LookUpIden(tok.Literal)
+			tok.Pos, tok.End = start, l.currentPosition()
 			return tok // Important as positing is already incremented in readIden()
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readIdenOrLiteral(isDigit)
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos, tok.End = start, l.currentPosition()
 			return tok // Important as positing is already incremented in readIden()
 		} else {
 			tok.Type = token.ELLEGAL
-			tok.Literal = ""
+			tok.Literal = l.input[l.position:l.readPosition]
 		}
 	}
 
 	l.ReadChar()
+	tok.Pos, tok.End = start, l.currentPosition()
 	return tok
 }
 
+// Reads an operator made of the current and the next character, like <= or &&
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.ReadChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
 // This function read a identifier or a literal value, it accept a validate func() which return a boolean value
 // It reads character continously, till it satisfy validate()
-func (l *Lexer) readIdenOrLiteral(validate func(byte) bool) string {
+func (l *Lexer) readIdenOrLiteral(validate func(rune) bool) string {
 	position := l.position
 	for validate(l.ch) {
 		l.ReadChar()
@@ -122,6 +204,45 @@ func (l *Lexer) readIdenOrLiteral(validate func(byte) bool) string {
 	return l.input[position:l.position]
 }
 
+// Reads an integer or a float literal. A float has a fraction, an exponent or both, like 1.5,
+// 1e9 or 2.5e-3. A '.' or 'e' which is not followed by a digit ends the number.
+func (l *Lexer) readNumber() (string, token.TokenType) {
+	position := l.position
+	tokenType := token.TokenType(token.INT)
+
+	l.readIdenOrLiteral(isDigit)
+	if l.ch == '.' && isDigit(l.peekCharAt(1)) {
+		tokenType = token.FLOAT
+		l.ReadChar()
+		l.readIdenOrLiteral(isDigit)
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		digits := 1
+		if next := l.peekCharAt(1); next == '+' || next == '-' {
+			digits = 2
+		}
+		if isDigit(l.peekCharAt(digits)) {
+			tokenType = token.FLOAT
+			for i := 0; i < digits; i++ {
+				l.ReadChar()
+			}
+			l.readIdenOrLiteral(isDigit)
+		}
+	}
+
+	return l.input[position:l.position], tokenType
+}
+
+// Character n bytes after the start of the current one, 0 past the end of input. Only used
+// while reading numbers, where the characters skipped over are ASCII.
+func (l *Lexer) peekCharAt(n int) rune {
+	if l.position+n >= len(l.input) {
+		return 0
+	}
+	ch, _ := utf8.DecodeRuneInString(l.input[l.position+n:])
+	return ch
+}
+
 // Function to read a string (can contain anything but should be enclosed within "" )
 func (l *Lexer) readString() string {
 	position := l.position + 1
@@ -143,10 +264,12 @@ func (l *Lexer) eatWhitespaces() {
 	}
 }
 
-func isLetter(char byte) bool {
-	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char == '_')
+// Identifiers are made of Unicode letters and '_'
+func isLetter(char rune) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char == '_') ||
+		(char >= utf8.RuneSelf && unicode.IsLetter(char))
 }
 
-func isDigit(char byte) bool {
+func isDigit(char rune) bool {
 	return char >= '0' && char <= '9'
 }
@@ -30,6 +30,10 @@ func TestLexer(t *testing.T) {
 	"foo bar"
 	[1,2];
 	{"foo":"bar"};
+	while for in break continue
+	&& || & | ^ ~ << >> <= >= %
+	<<= >>>
+	3.14 1e9 2.5E-3 1e+2 7. 1.e 5e x1.5
 	`
 
 	test := []struct {
@@ -113,6 +117,39 @@ func TestLexer(t *testing.T) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.AND, "&&"},
+		{token.OR, "||"},
+		{token.AMPERSAND, "&"},
+		{token.PIPE, "|"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.SHL, "<<"},
+		{token.SHR, ">>"},
+		{token.LT_EQ, "<="},
+		{token.GT_EQ, ">="},
+		{token.PERCENT, "%"},
+		{token.SHL, "<<"},
+		{token.ASSIGN, "="},
+		{token.SHR, ">>"},
+		{token.GT, ">"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "1e+2"},
+		{token.INT, "7"},
+		{token.ELLEGAL, "."},
+		{token.INT, "1"},
+		{token.ELLEGAL, "."},
+		{token.IDEN, "e"},
+		{token.INT, "5"},
+		{token.IDEN, "e"},
+		{token.IDEN, "x"},
+		{token.FLOAT, "1.5"},
 		{token.EOF, ""},
 	}
 
@@ -127,3 +164,73 @@ func TestLexer(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"hi\" +\nfoo"
+
+	test := []struct {
+		expectedType token.TokenType
+		pos          token.Position
+		end          token.Position
+	}{
+		{token.LET, token.Position{Filename: "main.mk", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "main.mk", Offset: 3, Line: 1, Column: 4}},
+		{token.IDEN, token.Position{Filename: "main.mk", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "main.mk", Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Filename: "main.mk", Offset: 6, Line: 1, Column: 7}, token.Position{Filename: "main.mk", Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Filename: "main.mk", Offset: 8, Line: 1, Column: 9}, token.Position{Filename: "main.mk", Offset: 9, Line: 1, Column: 10}},
+		{token.SEMICOLON, token.Position{Filename: "main.mk", Offset: 9, Line: 1, Column: 10}, token.Position{Filename: "main.mk", Offset: 10, Line: 1, Column: 11}},
+		{token.STRING, token.Position{Filename: "main.mk", Offset: 13, Line: 2, Column: 3}, token.Position{Filename: "main.mk", Offset: 17, Line: 2, Column: 7}},
+		{token.PLUS, token.Position{Filename: "main.mk", Offset: 18, Line: 2, Column: 8}, token.Position{Filename: "main.mk", Offset: 19, Line: 2, Column: 9}},
+		{token.IDEN, token.Position{Filename: "main.mk", Offset: 20, Line: 3, Column: 1}, token.Position{Filename: "main.mk", Offset: 23, Line: 3, Column: 4}},
+		{token.EOF, token.Position{Filename: "main.mk", Offset: 23, Line: 3, Column: 4}, token.Position{Filename: "main.mk", Offset: 23, Line: 3, Column: 4}},
+		{token.EOF, token.Position{Filename: "main.mk", Offset: 23, Line: 3, Column: 4}, token.Position{Filename: "main.mk", Offset: 23, Line: 3, Column: 4}},
+	}
+
+	l := NewWithFilename("main.mk", input)
+	for i, tt := range test {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Test_%d: Type mismatch Expected:%q Got:%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.pos {
+			t.Errorf("Test_%d: Pos mismatch Expected:%+v Got:%+v", i, tt.pos, tok.Pos)
+		}
+		if tok.End != tt.end {
+			t.Errorf("Test_%d: End mismatch Expected:%+v Got:%+v", i, tt.end, tok.End)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let größe = \"héllo, 世界\"; π_2 € \xff"
+
+	test := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		pos             token.Position
+	}{
+		{token.LET, "let", token.Position{Offset: 0, Line: 1, Column: 1}},
+		{token.IDEN, "größe", token.Position{Offset: 4, Line: 1, Column: 5}},
+		{token.ASSIGN, "=", token.Position{Offset: 12, Line: 1, Column: 11}},
+		{token.STRING, "héllo, 世界", token.Position{Offset: 14, Line: 1, Column: 13}},
+		{token.SEMICOLON, ";", token.Position{Offset: 30, Line: 1, Column: 24}},
+		{token.IDEN, "π_", token.Position{Offset: 32, Line: 1, Column: 26}},
+		{token.INT, "2", token.Position{Offset: 35, Line: 1, Column: 28}},
+		{token.ELLEGAL, "€", token.Position{Offset: 37, Line: 1, Column: 30}},
+		{token.ELLEGAL, "\xff", token.Position{Offset: 41, Line: 1, Column: 32}},
+		{token.EOF, "", token.Position{Offset: 42, Line: 1, Column: 33}},
+	}
+
+	l := New(input)
+	for i, tt := range test {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Test_%d: Type mismatch Expected:%q Got:%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Test_%d: Literal mismatch Expected:%q Got:%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos != tt.pos {
+			t.Errorf("Test_%d: Pos mismatch Expected:%+v Got:%+v", i, tt.pos, tok.Pos)
+		}
+	}
+}
@@ -8,7 +8,40 @@ import (
 	"github.com/ShivankSharma070/go-compiler/repl"
 )
 
+const usage = `Usage:
+  go-compiler                          start the interactive REPL
+  go-compiler run [--engine=vm|eval] [--overflow=promote|error] [-O level] <file>
+                                       run a Monkey source or bytecode file, use - to read from stdin
+  go-compiler build [-o output] [-stats] [-O level] <file>
+                                       compile a source file to bytecode (.mbc)
+  go-compiler disasm [-O level] [-compare] <file>
+                                       print the bytecode of a source or bytecode file
+
+Flags can come before or after the file, arguments after -- are never taken as flags.
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		startRepl()
+		return
+	}
+
+	switch os.Args[1] {
+	case "run":
+		os.Exit(runCommand(os.Args[2:]))
+	case "build":
+		os.Exit(buildCommand(os.Args[2:]))
+	case "disasm":
+		os.Exit(disasmCommand(os.Args[2:]))
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func startRepl() {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
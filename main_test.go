@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		source         string
+		flags          []string
+		expectedStatus int
+		expectedStderr string
+	}{
+		{`let x = 1 + 2; x`, nil, exitOK, ""},
+		{`let x = 1 + 2; x`, []string{"--engine=eval"}, exitOK, ""},
+		{"#!/usr/bin/env go-compiler run\n1", nil, exitOK, ""},
+		// The vm engine finds undefined names while compiling, the eval engine while running
+		{`y`, nil, exitError, "compile error: undefined variable: y"},
+		{`y`, []string{"--engine=vm"}, exitError, "compile error: undefined variable: y"},
+		{`y`, []string{"--engine=eval"}, exitError, "runtime error: identifier not found: y"},
+		{`let = 1;`, nil, exitError, "error:"},
+		{`let = 1;`, []string{"--engine=eval"}, exitError, "error:"},
+		{`1`, []string{"--engine=bogus"}, exitUsage, `unknown engine "bogus"`},
+		{`1`, []string{"--bogus"}, exitUsage, "flag provided but not defined"},
+	}
+
+	for _, tt := range tests {
+		path := writeScript(t, tt.source)
+		args := append(append([]string{}, tt.flags...), path)
+
+		status, stderr := captureStderr(t, func() int { return runCommand(args) })
+		if status != tt.expectedStatus {
+			t.Errorf("%q %q: wrong exit status. want=%d, got=%d", tt.source, tt.flags, tt.expectedStatus, status)
+		}
+		if !strings.Contains(stderr, tt.expectedStderr) {
+			t.Errorf("%q %q: stderr does not contain %q, got %q", tt.source, tt.flags, tt.expectedStderr, stderr)
+		}
+		if tt.expectedStderr == "" && stderr != "" {
+			t.Errorf("%q %q: unexpected stderr %q", tt.source, tt.flags, stderr)
+		}
+	}
+}
+
+func TestRunCommandArguments(t *testing.T) {
+	path := writeScript(t, `1`)
+
+	tests := []struct {
+		args           []string
+		expectedStatus int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{path, path}, exitUsage},
+		{[]string{filepath.Join(t.TempDir(), "missing.mk")}, exitError},
+	}
+
+	for _, tt := range tests {
+		status, _ := captureStderr(t, func() int { return runCommand(tt.args) })
+		if status != tt.expectedStatus {
+			t.Errorf("%q: wrong exit status. want=%d, got=%d", tt.args, tt.expectedStatus, status)
+		}
+	}
+}
+
+// Writes source to a file in a temporary directory and returns its path
+func writeScript(t *testing.T, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.mk")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Runs command with os.Stderr redirected, returning its result and everything it wrote there
+func captureStderr(t *testing.T, command func() int) (int, string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := os.Stderr
+	os.Stderr = w
+	status := command()
+	os.Stderr = stderr
+	w.Close()
+
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, string(output)
+}
+
+func TestFlagsBeforeOrAfterFile(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "prog.mk")
+	// Fails with an overflow error on the vm with --overflow=error, gives a big integer otherwise
+	err := os.WriteFile(source, []byte("9223372036854775807 + 1"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.mbc")
+
+	tests := []struct {
+		command  func([]string) int
+		args     []string
+		expected int
+	}{
+		{runCommand, []string{"--engine=eval", source}, exitOK},
+		{runCommand, []string{source, "--engine=eval"}, exitOK},
+		{runCommand, []string{source, "--engine=bogus"}, exitUsage},
+		{runCommand, []string{"--overflow=error", source}, exitError},
+		{runCommand, []string{source, "--overflow=error"}, exitError},
+		{runCommand, []string{source, "-O", "2", "--overflow=error"}, exitError},
+		{runCommand, []string{source, source}, exitUsage},
+		{runCommand, []string{"--", source}, exitOK},
+		{runCommand, []string{source, "--", "--engine=eval"}, exitUsage},
+		{buildCommand, []string{source, "-o", output}, exitOK},
+		{runCommand, []string{output, "--engine=eval"}, exitUsage},
+		{disasmCommand, []string{output, "-compare"}, exitUsage},
+	}
+
+	for _, tt := range tests {
+		if status := tt.command(tt.args); status != tt.expected {
+			t.Errorf("%q: wrong exit status. want=%d, got=%d", tt.args, tt.expected, status)
+		}
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("build did not write -o output: %s", err)
+	}
+}
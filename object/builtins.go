@@ -2,7 +2,12 @@ package object
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // ================== BUILT-IN FUNCTION ===================
@@ -21,6 +26,7 @@ var Builtins = []struct {
 	Buitlin *Builtin
 }{
 	{
+		// Counts the elements of an array or the code points of a string
 		"len",
 		&Builtin{
 			Fn: func(args ...Object) Object {
@@ -32,7 +38,7 @@ var Builtins = []struct {
 				case *Array:
 					return &Integer{Value: int64(len(arg.Elements))}
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 				default:
 					return newError("argument to `len` not supported, got %s", args[0].Type())
 				}
@@ -139,6 +145,79 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		// Truncates floats toward zero and parses strings
+		"int",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer, *BigInteger:
+					return arg
+				case *Float:
+					if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+						return newError("cannot convert %s to INTEGER", arg.Inspect())
+					}
+					if arg.Value < math.MinInt64 || arg.Value >= math.MaxInt64 {
+						value, _ := big.NewFloat(arg.Value).Int(nil)
+						return NewBigInteger(value)
+					}
+					return &Integer{Value: int64(arg.Value)}
+				case *String:
+					value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+					if !ok {
+						return newError("cannot convert %q to INTEGER", arg.Value)
+					}
+					return NewBigInteger(value)
+				default:
+					return newError("argument to `int` not supported, got %s", args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		"float",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer, *BigInteger:
+					return &Float{Value: IntegerToFloat(arg)}
+				case *Float:
+					return arg
+				case *String:
+					value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+					if err != nil {
+						return newError("cannot convert %q to FLOAT", arg.Value)
+					}
+					return &Float{Value: value}
+				default:
+					return newError("argument to `float` not supported, got %s", args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		// The length of a string in bytes of UTF-8, rather than in code points like len
+		"bytelen",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1", len(args))
+				}
+				if args[0].Type() != STRING_OBJ {
+					return newError("argument to `bytelen` must be STRING, got %s", args[0].Type())
+				}
+				return &Integer{Value: int64(len(args[0].(*String).Value))}
+			},
+		},
+	},
 }
 
 func GetBuiltinByName(name string) *Builtin {
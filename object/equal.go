@@ -0,0 +1,96 @@
+package object
+
+// ===================== EQUALITY ============================
+// Equal implements == for both engines. Numbers are equal if they have the same value, an
+// integer is converted when compared with a float. Strings, booleans and null compare by value,
+// arrays and hashes by their contents, recursively. Anything else, like functions, is only
+// equal to itself. Values of different types are never equal, and NaN is not even equal to
+// itself.
+
+func Equal(a, b Object) bool {
+	return newEqualizer().equal(a, b)
+}
+
+// Containers which are being compared. Finding the same two again means the structures are
+// cyclic, the comparison in progress decides whether they are equal so they are taken to be.
+type equalizer struct {
+	comparing map[[2]Object]bool
+}
+
+func newEqualizer() *equalizer {
+	return &equalizer{}
+}
+
+func (e *equalizer) equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer, *BigInteger:
+		switch b.(type) {
+		case *Integer, *BigInteger:
+			return CompareIntegers(a, b) == 0
+		case *Float:
+			return IntegerToFloat(a) == b.(*Float).Value
+		}
+		return false
+	case *Float:
+		switch b := b.(type) {
+		case *Integer, *BigInteger:
+			return a.Value == IntegerToFloat(b)
+		case *Float:
+			return a.Value == b.Value
+		}
+		return false
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		if !e.enter(a, b) {
+			return true
+		}
+		for i := range a.Elements {
+			if !e.equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Pairs.Len() != b.Pairs.Len() {
+			return false
+		}
+		if !e.enter(a, b) {
+			return true
+		}
+		for _, pair := range a.Pairs.Pairs() {
+			other, ok := b.Pairs.Get(pair.Key)
+			if !ok || !e.equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// Records that a and b are being compared, false if they already are
+func (e *equalizer) enter(a, b Object) bool {
+	if e.comparing == nil {
+		e.comparing = map[[2]Object]bool{}
+	}
+	key := [2]Object{a, b}
+	if e.comparing[key] {
+		return false
+	}
+	e.comparing[key] = true
+	return true
+}
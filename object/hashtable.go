@@ -0,0 +1,88 @@
+package object
+
+import "math"
+
+// ===================== HASH TABLE ============================
+// HashTable maps keys to values for hashes. A HashKey only picks the bucket a key goes in:
+// different keys can have the same HashKey, strings are hashed to 64 bits, so every bucket
+// holds a list of pairs and lookups compare the keys themselves. Keys match when they are
+// equal with ==, so a float without a fraction is the same key as the integer it equals: 1 and
+// 1.0 find the same pair. Pairs are kept in the order their keys were first set, which is the
+// order hashes are printed and iterated in.
+
+type HashTable struct {
+	buckets map[HashKey][]int // Indexes into pairs
+	pairs   []HashPair
+}
+
+func NewHashTable() *HashTable {
+	return &HashTable{buckets: map[HashKey][]int{}}
+}
+
+// Get returns the pair holding key, key must be Hashable
+func (t *HashTable) Get(key Object) (HashPair, bool) {
+	for _, i := range t.buckets[key.(Hashable).HashKey()] {
+		if keysEqual(t.pairs[i].Key, key) {
+			return t.pairs[i], true
+		}
+	}
+	return HashPair{}, false
+}
+
+// Set stores value under key. Setting a key which is already present replaces its value and
+// keeps its position. key must be Hashable.
+func (t *HashTable) Set(key, value Object) {
+	hashKey := key.(Hashable).HashKey()
+	for _, i := range t.buckets[hashKey] {
+		if keysEqual(t.pairs[i].Key, key) {
+			t.pairs[i].Value = value
+			return
+		}
+	}
+	t.buckets[hashKey] = append(t.buckets[hashKey], len(t.pairs))
+	t.pairs = append(t.pairs, HashPair{Key: key, Value: value})
+}
+
+// Len returns the number of pairs
+func (t *HashTable) Len() int { return len(t.pairs) }
+
+// Pairs returns every pair in insertion order
+func (t *HashTable) Pairs() []HashPair {
+	return append([]HashPair(nil), t.pairs...)
+}
+
+// Keys are equal if they have the same value, an integral float being compared as the integer
+// it equals. Like their HashKeys, 0.0 and -0.0 are the same key and so is every NaN with the
+// same bits.
+func keysEqual(a, b Object) bool {
+	if f, ok := a.(*Float); ok {
+		if integer, ok := floatToInteger(f.Value); ok {
+			a = integer
+		}
+	}
+	if f, ok := b.(*Float); ok {
+		if integer, ok := floatToInteger(f.Value); ok {
+			b = integer
+		}
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *BigInteger:
+		b, ok := b.(*BigInteger)
+		return ok && a.Value.Cmp(b.Value) == 0
+	case *Float:
+		b, ok := b.(*Float)
+		return ok && (a.Value == b.Value || math.Float64bits(a.Value) == math.Float64bits(b.Value))
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	default:
+		return a == b
+	}
+}
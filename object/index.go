@@ -0,0 +1,137 @@
+package object
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// ===================== INDEXING ============================
+// Indexing and slicing rules shared by both engines. Negative indices count from the end, so
+// -1 is the last element. Reading an index which is out of range gives null, the engines push
+// their own null for that, assigning to one is an error. Strings are indexed and sliced by
+// code point, not by byte.
+
+// ElementIndex returns the position index refers to in a sequence of length elements, false if
+// it is out of range. index is an Integer or a BigInteger.
+func ElementIndex(index Object, length int) (int, bool) {
+	i, ok := index.(*Integer)
+	if !ok {
+		// Big integers are out of range for anything that fits in memory
+		return 0, false
+	}
+	position := i.Value
+	if position < 0 {
+		position += int64(length)
+	}
+	if position < 0 || position >= int64(length) {
+		return 0, false
+	}
+	return int(position), true
+}
+
+// CharAt returns the code point at index of str as a string, false if index is out of range
+func CharAt(str string, index Object) (string, bool) {
+	i, ok := ElementIndex(index, utf8.RuneCountInString(str))
+	if !ok {
+		return "", false
+	}
+	start := runeOffset(str, i)
+	_, width := utf8.DecodeRuneInString(str[start:])
+	return str[start : start+width], true
+}
+
+// Slice returns the elements of an array, or the code points of a string, from start up to
+// but not including end. Bounds are Integers, BigIntegers or null when they are left out,
+// which means the start or the end. Bounds out of range are clamped, a start past the end gives
+// an empty result. The result is a copy, changing it does not change left.
+func Slice(left, start, end Object) (Object, error) {
+	var length int
+	switch left := left.(type) {
+	case *Array:
+		length = len(left.Elements)
+	case *String:
+		length = utf8.RuneCountInString(left.Value)
+	default:
+		return nil, fmt.Errorf("slice operator not supported: %s", left.Type())
+	}
+
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	to, err := sliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		to = from
+	}
+
+	if array, ok := left.(*Array); ok {
+		elements := make([]Object, to-from)
+		copy(elements, array.Elements[from:to])
+		return &Array{Elements: elements}, nil
+	}
+	str := left.(*String).Value
+	offset := runeOffset(str, from)
+	return &String{Value: str[offset : offset+runeOffset(str[offset:], to-from)]}, nil
+}
+
+// Byte offset of the nth code point of str, len(str) if it has n code points. Invalid UTF-8
+// counts as one code point per byte, like utf8.RuneCountInString counts it.
+func runeOffset(str string, n int) int {
+	if n == 0 {
+		return 0
+	}
+	for offset := range str {
+		if n == 0 {
+			return offset
+		}
+		n--
+	}
+	return len(str)
+}
+
+func sliceBound(bound Object, omitted, length int) (int, error) {
+	switch bound := bound.(type) {
+	case nil, *Null:
+		return omitted, nil
+	case *Integer:
+		position := bound.Value
+		if position < 0 {
+			position += int64(length)
+		}
+		return int(min(max(position, 0), int64(length))), nil
+	case *BigInteger:
+		if bound.Value.Sign() < 0 {
+			return 0, nil
+		}
+		return length, nil
+	default:
+		return 0, fmt.Errorf("slice index must be INTEGER, got %s", bound.Type())
+	}
+}
+
+// SetIndex stores value at index of an array or under the key index of a hash
+func SetIndex(left, index, value Object) error {
+	switch left := left.(type) {
+	case *Array:
+		if index.Type() != INTEGER_OBJ {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		i, ok := ElementIndex(index, len(left.Elements))
+		if !ok {
+			return fmt.Errorf("index out of range: %s with length %d", index.Inspect(), len(left.Elements))
+		}
+		left.Elements[i] = value
+		return nil
+	case *Hash:
+		if _, ok := index.(Hashable); !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs.Set(index, value)
+		return nil
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+}
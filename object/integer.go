@@ -0,0 +1,239 @@
+package object
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// ===================== BIG INTEGER ============================
+// Integers which do not fit in an int64 are held in a BigInteger. To programs both are the one
+// INTEGER type: arithmetic which overflows an int64 gives a BigInteger, and any result which
+// fits in an int64 is an Integer again, so a BigInteger never holds a value an Integer could.
+
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (b *BigInteger) Inspect() string  { return b.Value.String() }
+func (b *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+
+// Big integers are never equal to an Integer, their keys are kept apart by type
+func (b *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(b.Value.Bytes())
+	return HashKey{Type: bigIntegerKey, Value: h.Sum64()}
+}
+
+const bigIntegerKey ObjectType = "BIG_INTEGER"
+
+// Bits a shift may produce at most, past this a shift is an error rather than an attempt to
+// allocate an enormous number
+const MaxShiftBits = 1 << 20
+
+// NewBigInteger returns value as an Integer if it fits in an int64, as a BigInteger otherwise
+func NewBigInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInteger{Value: value}
+}
+
+// BigValue returns the value of an Integer or a BigInteger, ok is false for anything else
+func BigValue(obj Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInteger:
+		return obj.Value, true
+	default:
+		return nil, false
+	}
+}
+
+// IntegerToFloat converts an Integer or a BigInteger to the nearest float
+func IntegerToFloat(obj Object) float64 {
+	if i, ok := obj.(*Integer); ok {
+		return float64(i.Value)
+	}
+	f, _ := new(big.Float).SetInt(obj.(*BigInteger).Value).Float64()
+	return f
+}
+
+// Returns the Integer or BigInteger with exactly the value of f, false if f has a fraction or
+// is not finite
+func floatToInteger(f float64) (Object, bool) {
+	if math.IsInf(f, 0) || math.IsNaN(f) || f != math.Trunc(f) {
+		return nil, false
+	}
+	if f >= math.MinInt64 && f < math.MaxInt64 {
+		return &Integer{Value: int64(f)}, true
+	}
+	value, _ := big.NewFloat(f).Int(nil)
+	return NewBigInteger(value), true
+}
+
+// CompareIntegers returns -1, 0 or +1 as left is less than, equal to or greater than right,
+// which are Integers or BigIntegers
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	leftValue, _ := BigValue(left)
+	rightValue, _ := BigValue(right)
+	return leftValue.Cmp(rightValue)
+}
+
+// Int64Operation applies an arithmetic or bitwise operator to two int64s. ok is false if the
+// result does not fit in an int64 or the operation fails, IntegerOperation handles those.
+func Int64Operation(operator string, a, b int64) (result int64, ok bool) {
+	switch operator {
+	case "+":
+		result = a + b
+		// Overflow happened if both operands have a sign different from the result
+		return result, (a^result)&(b^result) >= 0
+	case "-":
+		result = a - b
+		return result, (a^b)&(a^result) >= 0
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		result = a * b
+		if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, false
+		}
+		return result, result/b == a
+	case "/":
+		if b == 0 || (a == math.MinInt64 && b == -1) {
+			return 0, false
+		}
+		return a / b, true
+	case "%":
+		if b == 0 {
+			return 0, false
+		}
+		if b == -1 {
+			return 0, true
+		}
+		return a % b, true
+	case "&":
+		return a & b, true
+	case "|":
+		return a | b, true
+	case "^":
+		return a ^ b, true
+	case "<<":
+		if b < 0 || b >= 64 {
+			return 0, a == 0 && b >= 0
+		}
+		// The bits shifted out, and the sign bit, must all equal the sign of a
+		if a >= 0 && bits.Len64(uint64(a))+int(b) >= 64 || a < 0 && bits.Len64(uint64(^a))+int(b) >= 64 {
+			return 0, false
+		}
+		return a << b, true
+	case ">>":
+		if b < 0 {
+			return 0, false
+		}
+		return a >> b, true
+	default:
+		return 0, false
+	}
+}
+
+// IntegerOperation applies an arithmetic or bitwise operator to two integers, Integers or
+// BigIntegers, with arbitrary precision. Division and remainder truncate toward zero like
+// they do for int64s. Dividing by zero and shifting by a negative or huge count are errors.
+func IntegerOperation(operator string, left, right Object) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if result, ok := Int64Operation(operator, l.Value, r.Value); ok {
+			return &Integer{Value: result}, nil
+		}
+	}
+
+	a, _ := BigValue(left)
+	b, _ := BigValue(right)
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		result.Add(a, b)
+	case "-":
+		result.Sub(a, b)
+	case "*":
+		result.Mul(a, b)
+	case "/", "%":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if operator == "/" {
+			result.Quo(a, b)
+		} else {
+			result.Rem(a, b)
+		}
+	case "&":
+		result.And(a, b)
+	case "|":
+		result.Or(a, b)
+	case "^":
+		result.Xor(a, b)
+	case "<<", ">>":
+		if b.Sign() < 0 {
+			return nil, fmt.Errorf("negative shift count: %s", b)
+		}
+		if operator == ">>" {
+			if !b.IsInt64() || b.Int64() > int64(a.BitLen()) {
+				// Everything is shifted out, what is left is the sign
+				return &Integer{Value: int64(min(a.Sign(), 0))}, nil
+			}
+			result.Rsh(a, uint(b.Int64()))
+			break
+		}
+		if a.Sign() == 0 {
+			return &Integer{Value: 0}, nil
+		}
+		if !b.IsInt64() || b.Int64()+int64(a.BitLen()) > MaxShiftBits {
+			return nil, fmt.Errorf("shift count too large: %s", b)
+		}
+		result.Lsh(a, uint(b.Int64()))
+	default:
+		return nil, fmt.Errorf("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	return NewBigInteger(result), nil
+}
+
+// NegateInteger returns -obj for an Integer or a BigInteger
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	value, _ := BigValue(obj)
+	return NewBigInteger(new(big.Int).Neg(value))
+}
+
+// NotInteger returns the bitwise complement of an Integer or a BigInteger, which is -obj - 1
+func NotInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok {
+		return &Integer{Value: ^i.Value}
+	}
+	return NewBigInteger(new(big.Int).Not(obj.(*BigInteger).Value))
+}
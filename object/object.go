@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/ShivankSharma070/go-compiler/ast"
 	"github.com/ShivankSharma070/go-compiler/code"
+	"github.com/ShivankSharma070/go-compiler/token"
 )
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
 	NULL_OBJ              = "NULL"
@@ -23,6 +27,10 @@ const (
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 	CLOUSRE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
+	ITERATOR_OBJ          = "ITERATOR"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
 )
 
 type ObjectType string
@@ -53,6 +61,29 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+type Float struct {
+	Value float64
+}
+
+// Floats always show a fraction or an exponent, so 2.0 can not be mistaken for the integer 2
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// A float equal to an integer is the same key as that integer, which makes 0.0 and -0.0 the
+// same key too
+func (f *Float) HashKey() HashKey {
+	if integer, ok := floatToInteger(f.Value); ok {
+		return integer.(Hashable).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -97,8 +128,21 @@ func (r *ReturnValue) Type() ObjectType {
 	return RETURN_VALUE_OBJ
 }
 
+// Break and Continue are what the evaluator gives for break and continue statements, they
+// unwind evaluation up to the innermost loop like ReturnValue does up to the function
+type Break struct{}
+
+func (b *Break) Inspect() string  { return "break" }
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+type Continue struct{}
+
+func (c *Continue) Inspect() string  { return "continue" }
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
 type Error struct {
 	Message string
+	Pos     token.Position // Where the error was raised, set by the evaluator
 }
 
 func (e *Error) Inspect() string  { return "Error: " + e.Message }
@@ -133,11 +177,13 @@ func (fe *FunctionLiteral) Type() ObjectType {
 type Environment struct {
 	Store map[string]Object
 	Outer *Environment
+	Depth int // Number of function calls active while the environment is in use
 }
 
 func NewEnclosingEnvironment(enclosingEnv *Environment) *Environment {
 	env := NewEnvironment()
 	env.Outer = enclosingEnv
+	env.Depth = enclosingEnv.Depth
 	return env
 }
 
@@ -159,26 +205,25 @@ func (e *Environment) Set(name string, value Object) Object {
 	return value
 }
 
+// Assign changes an existing variable in the environment which defined it, so every closure
+// sharing that environment sees the new value. It reports false if name is not defined.
+func (e *Environment) Assign(name string, value Object) bool {
+	for env := e; env != nil; env = env.Outer {
+		if _, ok := env.Store[name]; ok {
+			env.Store[name] = value
+			return true
+		}
+	}
+	return false
+}
+
 // =================== ARRAY =========================
 type Array struct {
 	Elements []Object
 }
 
 func (ar *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ar *Array) Inspect() string {
-	var out bytes.Buffer
-
-	elements := []string{}
-	for _, e := range ar.Elements {
-		elements = append(elements, e.Inspect())
-	}
-
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-
-	return out.String()
-}
+func (ar *Array) Inspect() string  { return inspectNested(ar, map[Object]bool{}) }
 
 // ==================== HASH ============================
 type HashPair struct {
@@ -187,34 +232,136 @@ type HashPair struct {
 }
 
 type Hash struct {
-	Pair map[HashKey]HashPair
+	Pairs *HashTable
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: NewHashTable()}
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return inspectNested(h, map[Object]bool{}) }
+
+// Arrays and hashes can contain themselves once elements are assigned to. A container which is
+// already being printed further out shows as [...] or {...}.
+func inspectNested(obj Object, printing map[Object]bool) string {
 	var out bytes.Buffer
-	pairs := []string{}
-	for _, pair := range h.Pair {
-		pairs = append(pairs, fmt.Sprintf("%s : %s", pair.Key.Inspect(), pair.Value.Inspect()))
+
+	switch obj := obj.(type) {
+	case *Array:
+		if printing[obj] {
+			return "[...]"
+		}
+		printing[obj] = true
+		defer delete(printing, obj)
+
+		elements := []string{}
+		for _, e := range obj.Elements {
+			elements = append(elements, inspectNested(e, printing))
+		}
+
+		out.WriteString("[")
+		out.WriteString(strings.Join(elements, ", "))
+		out.WriteString("]")
+	case *Hash:
+		if printing[obj] {
+			return "{...}"
+		}
+		printing[obj] = true
+		defer delete(printing, obj)
+
+		pairs := []string{}
+		for _, pair := range obj.Pairs.Pairs() {
+			pairs = append(pairs, fmt.Sprintf("%s : %s", pair.Key.Inspect(), inspectNested(pair.Value, printing)))
+		}
+		out.WriteString("{")
+		out.WriteString(strings.Join(pairs, ", "))
+		out.WriteString("}")
+	default:
+		return obj.Inspect()
 	}
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+
 	return out.String()
 }
 
+// ===================== ITERATOR ============================
+// Iterator steps through an array or a hash for a for loop. It takes the elements when it is
+// created, so the loop is not affected by what its body does to the variable it iterates over.
+type Iterator struct {
+	keys   []Object
+	values []Object
+	hash   bool
+	next   int
+}
+
+// NewIterator returns an iterator over an array or a hash, false for anything else
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		it := &Iterator{values: append([]Object(nil), obj.Elements...)}
+		for i := range obj.Elements {
+			it.keys = append(it.keys, &Integer{Value: int64(i)})
+		}
+		return it, true
+	case *Hash:
+		it := &Iterator{hash: true}
+		for _, pair := range obj.Pairs.Pairs() {
+			it.keys = append(it.keys, pair.Key)
+			it.values = append(it.values, pair.Value)
+		}
+		return it, true
+	default:
+		return nil, false
+	}
+}
+
+// Next returns the values of the loop variables for the next iteration, in the order they are
+// written, or false once there are none left. Two variables get the index and element of an
+// array or the key and value of a hash, a single one gets the element of an array or the key
+// of a hash.
+func (it *Iterator) Next(variables int) ([]Object, bool) {
+	if it.next >= len(it.keys) {
+		return nil, false
+	}
+	key, value := it.keys[it.next], it.values[it.next]
+	it.next++
+
+	switch {
+	case variables == 2:
+		return []Object{key, value}, true
+	case it.hash:
+		return []Object{key}, true
+	default:
+		return []Object{value}, true
+	}
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return fmt.Sprintf("Iterator[%d/%d]", it.next, len(it.keys)) }
+
 // =================== COMPILED FUNCTION ====================
 type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+
+	Name  string         // Name the function was bound to with let, empty for anonymous functions
+	Lines code.LineTable // Maps instruction offsets to source positions
+
+	// Names of locals and free variables by index, only used for disassembly
+	LocalNames []string
+	FreeNames  []string
 }
 
 func (cf *CompiledFunction) Type() ObjectType {
 	return COMPILED_FUNCTION_OBJ
 }
 func (cf *CompiledFunction) Inspect() string {
-	return fmt.Sprintf("CompiledFunction[%p]", cf)
+	name := cf.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	return fmt.Sprintf("CompiledFunction[%s/%d]", name, cf.NumParameters)
 
 }
 
@@ -231,3 +378,14 @@ func (c *Closure) Type() ObjectType{
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
+
+// ===================== CELL ============================
+// Cell holds a variable of the VM which is captured by closures and assigned to, so that every
+// closure and the frame defining the variable share it. Cells are never Monkey values, reading
+// the variable always reads the value inside.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return fmt.Sprintf("Cell[%s]", c.Value.Inspect()) }
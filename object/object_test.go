@@ -1,6 +1,10 @@
 package object
 
 import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -19,3 +23,291 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+		{1.5e-7, "1.5e-07"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+
+	zero := &Float{Value: 0}
+	negativeZero := &Float{Value: math.Copysign(0, -1)}
+	if zero.HashKey() != negativeZero.HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+	if zero.HashKey() != (&Integer{Value: 0}).HashKey() {
+		t.Errorf("0.0 and 0 have different hash keys")
+	}
+	if (&Float{Value: 0.5}).HashKey().Type != FLOAT_OBJ {
+		t.Errorf("0.5 does not have a float hash key")
+	}
+}
+
+func TestBigInteger(t *testing.T) {
+	value, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	b := &BigInteger{Value: value}
+	if b.Inspect() != "-123456789012345678901234567890" {
+		t.Errorf("wrong Inspect. got=%q", b.Inspect())
+	}
+	if b.Type() != INTEGER_OBJ {
+		t.Errorf("wrong Type. got=%s", b.Type())
+	}
+
+	same := &BigInteger{Value: new(big.Int).Set(value)}
+	if b.HashKey() != same.HashKey() {
+		t.Errorf("big integers with the same value have different hash keys")
+	}
+	if b.HashKey() == (&BigInteger{Value: new(big.Int).Neg(value)}).HashKey() {
+		t.Errorf("big integers with opposite signs have the same hash key")
+	}
+
+	if _, ok := NewBigInteger(big.NewInt(42)).(*Integer); !ok {
+		t.Errorf("NewBigInteger does not give an Integer for a value which fits in an int64")
+	}
+}
+
+func TestIntegerOperation(t *testing.T) {
+	tests := []struct {
+		operator    string
+		left, right int64
+		expected    string
+	}{
+		{"+", math.MaxInt64, 1, "9223372036854775808"},
+		{"-", math.MinInt64, 1, "-9223372036854775809"},
+		{"*", math.MaxInt64, 2, "18446744073709551614"},
+		{"*", math.MinInt64, -1, "9223372036854775808"},
+		{"/", math.MinInt64, -1, "9223372036854775808"},
+		{"%", math.MinInt64, -1, "0"},
+		{"<<", 1, 63, "9223372036854775808"},
+		{"<<", -1, 63, "-9223372036854775808"},
+		{">>", -1, 100, "-1"},
+		{"+", math.MaxInt64, math.MinInt64, "-1"},
+		{"*", -3, 4, "-12"},
+		{"/", -7, 2, "-3"},
+	}
+
+	for _, tt := range tests {
+		result, err := IntegerOperation(tt.operator, &Integer{Value: tt.left}, &Integer{Value: tt.right})
+		if err != nil {
+			t.Errorf("%d %s %d: unexpected error %s", tt.left, tt.operator, tt.right, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%d %s %d: want %s, got %s", tt.left, tt.operator, tt.right, tt.expected, result.Inspect())
+		}
+
+		// Results which fit are never big integers, so Int64Operation must agree with them
+		value, ok := Int64Operation(tt.operator, tt.left, tt.right)
+		if _, isBig := result.(*BigInteger); ok == isBig || ok && fmt.Sprint(value) != tt.expected {
+			t.Errorf("%d %s %d: Int64Operation gives %d, %t", tt.left, tt.operator, tt.right, value, ok)
+		}
+	}
+
+	errors := []struct {
+		operator    string
+		left, right int64
+		expected    string
+	}{
+		{"/", 1, 0, "division by zero"},
+		{"%", 1, 0, "division by zero"},
+		{"<<", 1, -1, "negative shift count: -1"},
+		{"<<", 1, MaxShiftBits, "shift count too large: 1048576"},
+	}
+
+	for _, tt := range errors {
+		_, err := IntegerOperation(tt.operator, &Integer{Value: tt.left}, &Integer{Value: tt.right})
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%d %s %d: want error %q, got %v", tt.left, tt.operator, tt.right, tt.expected, err)
+		}
+	}
+}
+
+// Every collidingKey has the same HashKey, like strings whose digests collide
+type collidingKey struct{ name string }
+
+func (k *collidingKey) Type() ObjectType { return "COLLIDING" }
+func (k *collidingKey) Inspect() string  { return k.name }
+func (k *collidingKey) HashKey() HashKey { return HashKey{Type: k.Type(), Value: 42} }
+
+func TestHashTable(t *testing.T) {
+	table := NewHashTable()
+	a, b := &collidingKey{"a"}, &collidingKey{"b"}
+	table.Set(a, &Integer{Value: 1})
+	table.Set(b, &Integer{Value: 2})
+	table.Set(&String{Value: "x"}, &Integer{Value: 3})
+	table.Set(&String{Value: "x"}, &Integer{Value: 4})
+	table.Set(&Float{Value: 0}, &Integer{Value: 5})
+	table.Set(&Float{Value: math.Copysign(0, -1)}, &Integer{Value: 6})
+	// Integral floats and integers with the same value are the same key, the key set first is
+	// the one kept
+	table.Set(&Integer{Value: 0}, &Integer{Value: 7})
+	table.Set(&Float{Value: 1e30}, &Integer{Value: 8})
+	huge, _ := new(big.Int).SetString("1000000000000000019884624838656", 10)
+	table.Set(&BigInteger{Value: huge}, &Integer{Value: 9})
+	table.Set(&Float{Value: 0.5}, &Integer{Value: 10})
+
+	if table.Len() != 6 {
+		t.Errorf("wrong Len. want=6, got=%d", table.Len())
+	}
+
+	tests := []struct {
+		key      Object
+		expected int64
+	}{
+		{a, 1},
+		{b, 2},
+		{&String{Value: "x"}, 4},
+		{&Float{Value: 0}, 7},
+		{&Integer{Value: 0}, 7},
+		{&BigInteger{Value: huge}, 9},
+		{&Float{Value: 1e30}, 9},
+		{&Float{Value: 0.5}, 10},
+	}
+	for _, tt := range tests {
+		pair, ok := table.Get(tt.key)
+		if !ok {
+			t.Errorf("no pair for %s", tt.key.Inspect())
+			continue
+		}
+		if pair.Value.(*Integer).Value != tt.expected {
+			t.Errorf("wrong value for %s. want=%d, got=%s", tt.key.Inspect(), tt.expected, pair.Value.Inspect())
+		}
+	}
+
+	var order []string
+	for _, pair := range table.Pairs() {
+		order = append(order, pair.Key.Inspect())
+	}
+	if strings.Join(order, " ") != "a b x 0.0 1e+30 0.5" {
+		t.Errorf("pairs are not in insertion order, got %q", order)
+	}
+
+	hash := &Hash{Pairs: table}
+	if hash.Inspect() != "{a : 1, b : 2, x : 4, 0.0 : 7, 1e+30 : 9, 0.5 : 10}" {
+		t.Errorf("wrong Inspect. got=%q", hash.Inspect())
+	}
+
+	if _, ok := table.Get(&collidingKey{"c"}); ok {
+		t.Errorf("found a pair for a key which was never set")
+	}
+	if _, ok := table.Get(&Float{Value: 1e30 + 1e15}); ok {
+		t.Errorf("found a pair for a key which was never set")
+	}
+	if _, ok := table.Get(&String{Value: "y"}); ok {
+		t.Errorf("found a pair for a key which was never set")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	hash := func(pairs ...Object) *Hash {
+		h := NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			h.Pairs.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	str := func(s string) *String { return &String{Value: s} }
+	integer := func(i int64) *Integer { return &Integer{Value: i} }
+	nan := &Float{Value: math.NaN()}
+	fn := &Builtin{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{str("a"), str("a"), true},
+		{str("a"), str("b"), false},
+		{integer(1), &Float{Value: 1}, true},
+		{integer(1), str("1"), false},
+		{&Null{}, &Null{}, true},
+		{&Null{}, &Boolean{Value: false}, false},
+		{nan, nan, false},
+		{fn, fn, true},
+		{fn, &Builtin{}, false},
+		{array(integer(1), array(str("x"))), array(integer(1), array(str("x"))), true},
+		{array(integer(1), integer(2)), array(integer(2), integer(1)), false},
+		{array(integer(1)), array(integer(1), integer(1)), false},
+		{hash(str("a"), integer(1), str("b"), integer(2)), hash(str("b"), integer(2), str("a"), integer(1)), true},
+		{hash(str("a"), integer(1)), hash(str("a"), integer(2)), false},
+		{hash(str("a"), integer(1)), hash(str("b"), integer(1)), false},
+		{hash(integer(1), integer(1)), hash(&Float{Value: 1}, integer(1)), true},
+		{hash(integer(1), integer(1)), hash(&Float{Value: 1.5}, integer(1)), false},
+	}
+
+	for _, tt := range tests {
+		if Equal(tt.a, tt.b) != tt.expected {
+			t.Errorf("Equal(%s, %s) is not %t", tt.a.Inspect(), tt.b.Inspect(), tt.expected)
+		}
+	}
+
+	// Arrays which contain themselves
+	a, b, c := array(integer(1), nil), array(integer(1), nil), array(integer(2), nil)
+	a.Elements[1], b.Elements[1], c.Elements[1] = a, b, c
+	if !Equal(a, b) {
+		t.Errorf("equal cyclic arrays are not equal")
+	}
+	if Equal(a, c) {
+		t.Errorf("different cyclic arrays are equal")
+	}
+}
+
+func TestIterator(t *testing.T) {
+	hash := NewHash()
+	for _, key := range []Object{&String{Value: "b"}, &Integer{Value: 2}, &String{Value: "a"}, &Integer{Value: -1}, &Boolean{Value: true}} {
+		hash.Pairs.Set(key, &String{Value: key.Inspect()})
+	}
+	array := &Array{Elements: []Object{&String{Value: "x"}, &String{Value: "y"}}}
+
+	tests := []struct {
+		iterable  Object
+		variables int
+		expected  []string
+	}{
+		{array, 1, []string{"x", "y"}},
+		{array, 2, []string{"0 x", "1 y"}},
+		{hash, 1, []string{"b", "2", "a", "-1", "true"}},
+		{hash, 2, []string{"b b", "2 2", "a a", "-1 -1", "true true"}},
+		{&Array{}, 1, nil},
+	}
+
+	for _, tt := range tests {
+		it, ok := NewIterator(tt.iterable)
+		if !ok {
+			t.Fatalf("can not iterate over %s", tt.iterable.Inspect())
+		}
+		var got []string
+		for {
+			values, ok := it.Next(tt.variables)
+			if !ok {
+				break
+			}
+			var parts []string
+			for _, v := range values {
+				parts = append(parts, v.Inspect())
+			}
+			got = append(got, strings.Join(parts, " "))
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("wrong iteration over %s with %d variables. want=%q, got=%q", tt.iterable.Inspect(), tt.variables, tt.expected, got)
+		}
+	}
+
+	if _, ok := NewIterator(&Integer{Value: 1}); ok {
+		t.Errorf("expected integers not to be iterable")
+	}
+}
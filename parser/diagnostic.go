@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ShivankSharma070/go-compiler/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// Diagnostic is a single problem found while parsing, along with the span of source it refers to.
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position // Start of the offending source text
+	End      token.Position // Position immediately after the offending source text
+	Message  string
+
+	Expected token.TokenType // Token type the parser was looking for, empty if not applicable
+	Actual   token.Token     // Token the parser found instead
+	Hint     string          // Optional suggestion on how to fix the problem
+}
+
+// Error returns the diagnostic in the form file:line:column: severity: message
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Message)
+}
+
+// Render formats the diagnostic along with the offending source line and a caret marking the span.
+// source must be the same text the lexer was given.
+func (d *Diagnostic) Render(source string) string {
+	var out bytes.Buffer
+	out.WriteString(d.Error())
+	out.WriteString("\n")
+
+	if d.Pos.IsValid() {
+		line := sourceLine(source, d.Pos.Line)
+		width := 1
+		if d.End.Line == d.Pos.Line && d.End.Column > d.Pos.Column {
+			width = d.End.Column - d.Pos.Column
+		}
+
+		// Keep tabs in the padding so that the caret lines up with the source line. Columns
+		// count characters, not bytes.
+		var padding strings.Builder
+		column := 1
+		for _, ch := range line {
+			if column >= d.Pos.Column {
+				break
+			}
+			if ch == '\t' {
+				padding.WriteByte('\t')
+			} else {
+				padding.WriteByte(' ')
+			}
+			column++
+		}
+
+		fmt.Fprintf(&out, "    %s\n", line)
+		fmt.Fprintf(&out, "    %s%s\n", padding.String(), strings.Repeat("^", width))
+	}
+
+	if d.Hint != "" {
+		fmt.Fprintf(&out, "hint: %s\n", d.Hint)
+	}
+
+	return out.String()
+}
+
+// RenderDiagnostics renders every diagnostic against the same source
+func RenderDiagnostics(source string, diagnostics []*Diagnostic) string {
+	var out bytes.Buffer
+	for _, d := range diagnostics {
+		out.WriteString(d.Render(source))
+	}
+	return out.String()
+}
+
+// Returns the line (1 based) of source without its trailing newline
+func sourceLine(source string, line int) string {
+	lines := strings.Split(source, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// Human readable description of a token, used in diagnostic messages
+func describeToken(tok token.Token) string {
+	switch tok.Type {
+	case token.EOF:
+		return "end of input"
+	case token.IDEN:
+		return fmt.Sprintf("identifier %q", tok.Literal)
+	case token.INT:
+		return fmt.Sprintf("integer %s", tok.Literal)
+	case token.STRING:
+		return fmt.Sprintf("string %q", tok.Literal)
+	case token.ELLEGAL:
+		return fmt.Sprintf("illegal character %q", tok.Literal)
+	default:
+		return fmt.Sprintf("%q", tok.Literal)
+	}
+}
+
+// Human readable description of a token type we expected to see
+func describeTokenType(t token.TokenType) string {
+	switch t {
+	case token.IDEN:
+		return "identifier"
+	case token.INT:
+		return "integer"
+	case token.STRING:
+		return "string"
+	case token.EOF:
+		return "end of input"
+	case token.IN:
+		return `"in"`
+	default:
+		return fmt.Sprintf("%q", string(t))
+	}
+}
@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"github.com/ShivankSharma070/go-compiler/ast"
@@ -13,24 +15,38 @@ import (
 const (
 	_ = iota
 	LOWEST
+	ASSIGN      // x = y
+	OR          // ||
+	AND         // &&
 	EQUALS      // == or !=
-	LESSGREATER // > or <
-	SUM         // + or -
-	PRODUCT     // * or /
-	PREFIX      // -X or !X
+	LESSGREATER // >, <, >= or <=
+	SUM         // +, -, | or ^
+	PRODUCT     // *, /, %, &, << or >>
+	PREFIX      // -X, !X or ~X
 	CALL        // myfunction(x)
 	INDEX 		// Array index
 )
 
 var precedence = map[token.TokenType]int{
+	token.ASSIGN:  ASSIGN,
+	token.OR:      OR,
+	token.AND:     AND,
 	token.EQ:      EQUALS,
 	token.NOT_EQ:  EQUALS,
 	token.LT:      LESSGREATER,
 	token.GT:      LESSGREATER,
+	token.LT_EQ:   LESSGREATER,
+	token.GT_EQ:   LESSGREATER,
 	token.PLUS:    SUM,
 	token.MINUS:   SUM,
+	token.PIPE:    SUM,
+	token.CARET:   SUM,
 	token.SLASH:   PRODUCT,
 	token.ASTERIK: PRODUCT,
+	token.PERCENT: PRODUCT,
+	token.AMPERSAND: PRODUCT,
+	token.SHL:     PRODUCT,
+	token.SHR:     PRODUCT,
 	token.LPAREN:  CALL,
 	token.LBRACKET : INDEX, 
 }
@@ -39,7 +55,14 @@ type Parser struct {
 	l            *lexer.Lexer
 	currentToken token.Token
 	peekToken    token.Token
-	errors       []string
+	errors       []*Diagnostic
+
+	// Set after an error is reported, further errors are suppressed till the parser
+	// synchronizes at the start of next statement. This stops one mistake from cascading.
+	panicking bool
+	depth     int // Number of currently open braces, used while synchronizing
+
+	loops int // Number of loops around the current statement inside the current function
 
 	// Maps to associate a token with a parser function
 	prefixParserMap map[token.TokenType]prefixParserFunc
@@ -51,19 +74,31 @@ type (
 	infixParserFunc  func(ast.Expression) ast.Expression // For token found in infix position
 )
 
+// Errors returns all error diagnostics formatted as file:line:column: error: message
 func (p *Parser) Errors() []string {
+	errors := []string{}
+	for _, d := range p.errors {
+		errors = append(errors, d.Error())
+	}
+	return errors
+}
+
+// Diagnostics returns structured diagnostics, in the order they were found
+func (p *Parser) Diagnostics() []*Diagnostic {
 	return p.errors
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []*Diagnostic{}}
 
 	// Prefix Functions
 	p.prefixParserMap = map[token.TokenType]prefixParserFunc{}
 	p.registerPrefix(token.IDEN, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBooleanExpression)
 	p.registerPrefix(token.FALSE, p.parseBooleanExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
@@ -83,8 +118,19 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERIK, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 
 	// Read Two tokens so that currentToken and peekToken are set
 	p.nextToken()
@@ -96,6 +142,15 @@ func New(l *lexer.Lexer) *Parser {
 func (p *Parser) nextToken() {
 	p.currentToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch p.currentToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		if p.depth > 0 {
+			p.depth--
+		}
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -108,6 +163,10 @@ func (p *Parser) ParseProgram() *ast.Program {
 			program.Statements = append(program.Statements, stmt)
 		}
 
+		if p.panicking {
+			p.synchronize(0)
+		}
+
 		p.nextToken()
 	}
 
@@ -116,10 +175,19 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.currentToken.Type {
+	case token.SEMICOLON:
+		// Empty statement
+		return nil
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -168,6 +236,94 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+// for (x in iterable) { ... } or for (k, v in iterable) { ... }
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDEN) {
+		return nil
+	}
+	stmt.Value = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if p.isPeekToken(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDEN) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	defer func() { p.loops-- }()
+	return p.parseBlockExpression()
+}
+
+// Parses break or continue, which are only allowed inside of a loop
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.currentToken
+
+	if p.isPeekToken(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if p.loops == 0 {
+		p.errorAt(tok, fmt.Sprintf("%s outside of a loop", tok.Literal), "break and continue can only be used inside of a while or for loop")
+		return nil
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
 func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.currentToken}
 
@@ -183,7 +339,7 @@ func (p *Parser) parseExpressionStatement() ast.Statement {
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParserMap[p.currentToken.Type]
 	if prefix == nil {
-		p.errors = append(p.errors, fmt.Sprintf("No prefix parsing function found for %s", p.currentToken.Literal))
+		p.noPrefixParserError()
 		return nil
 	}
 
@@ -213,9 +369,25 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.currentToken}
 
 	value, err := strconv.ParseInt(lit.TokenLiteral(), 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if bigValue, ok := new(big.Int).SetString(lit.TokenLiteral(), 0); ok {
+			return &ast.BigIntegerLiteral{Token: p.currentToken, Value: bigValue}
+		}
+	}
 	if err != nil {
-		err := fmt.Sprintf("Could not parse %q as integer", lit.TokenLiteral())
-		p.errors = append(p.errors, err)
+		p.errorAt(p.currentToken, fmt.Sprintf("could not parse %q as integer", lit.TokenLiteral()), "")
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.currentToken}
+
+	value, err := strconv.ParseFloat(lit.TokenLiteral(), 64)
+	if err != nil {
+		p.errorAt(p.currentToken, fmt.Sprintf("could not parse %q as float", lit.TokenLiteral()), "")
 	}
 
 	lit.Value = value
@@ -253,6 +425,33 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// Assignment is right associative, a = b = 1 assigns 1 to both
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{Token: p.currentToken}
+
+	if target, ok := left.(*ast.IndexExpression); ok {
+		assign := &ast.IndexAssignExpression{Token: p.currentToken, Target: target}
+		p.nextToken()
+		assign.Value = p.parseExpression(ASSIGN - 1)
+		return assign
+	}
+
+	name, ok := left.(*ast.Identifier)
+	if !ok {
+		if left == nil {
+			return nil
+		}
+		p.errorAt(p.currentToken, fmt.Sprintf("cannot assign to %s", left.String()), "only variables and elements can be assigned to")
+		return nil
+	}
+	exp.Name = name
+
+	p.nextToken()
+	exp.Value = p.parseExpression(ASSIGN - 1)
+
+	return exp
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 
@@ -313,7 +512,11 @@ func (p *Parser) parseFunctionExpression() ast.Expression {
 		return nil
 	}
 
+	// Loops around the function do not continue inside of it
+	loops := p.loops
+	p.loops = 0
 	function.Body = p.parseBlockExpression()
+	p.loops = loops
 
 	return function
 }
@@ -347,6 +550,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseBlockExpression() *ast.BlockStatement {
 	blockStmt := &ast.BlockStatement{Token: p.currentToken}
 	blockStmt.Statements = []ast.Statement{}
+	depth := p.depth
 
 	p.nextToken()
 
@@ -355,8 +559,17 @@ func (p *Parser) parseBlockExpression() *ast.BlockStatement {
 		if stmt != nil {
 			blockStmt.Statements = append(blockStmt.Statements, stmt)
 		}
+
+		if p.panicking {
+			p.synchronize(depth)
+			// Synchronizing may stop on the brace closing this block, it must not be skipped
+			if p.isCurToken(token.RBRACE) && p.depth < depth {
+				break
+			}
+		}
 		p.nextToken()
 	}
+	blockStmt.RBrace = p.currentToken
 
 	return blockStmt
 }
@@ -364,6 +577,7 @@ func (p *Parser) parseBlockExpression() *ast.BlockStatement {
 func (p *Parser) parseCallExpression(exp ast.Expression) ast.Expression {
 	callExp := &ast.CallExpression{Token: p.currentToken, Function: exp}
 	callExp.Argument = p.parseCallArgument()
+	callExp.RParen = p.currentToken
 	return callExp
 }
 
@@ -376,6 +590,7 @@ func (p *Parser) parseCallArgument() []ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.currentToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.RBracket = p.currentToken
 	return array
 }
 
@@ -402,14 +617,42 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	return list
 }
 
+// Parses a[i] as well as the slice a[low:high], in which both bounds are optional
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression{
 	exp := &ast.IndexExpression{Token: p.currentToken, Left:left}
+
+	var low ast.Expression
+	if !p.isPeekToken(token.COLON) {
+		p.nextToken()
+		low = p.parseExpression(LOWEST)
+	}
+	if p.isPeekToken(token.COLON) {
+		return p.parseSliceExpression(exp.Token, left, low)
+	}
+	exp.Index = low
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	exp.RBracket = p.currentToken
+
+	return exp
+}
+
+// The next token is the colon following the lower bound
+func (p *Parser) parseSliceExpression(lbracket token.Token, left, low ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: lbracket, Left: left, Low: low}
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+
+	if !p.isPeekToken(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.RBracket = p.currentToken
 
 	return exp
 }
@@ -437,6 +680,7 @@ func (p *Parser) parseHashLiteral() ast.Expression{
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.RBrace = p.currentToken
 	return hash
 }
 
@@ -459,8 +703,97 @@ func (p *Parser) isPeekToken(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	err := fmt.Sprintf("Expected next token to be %s , got %s", t, p.peekToken.Type)
-	p.errors = append(p.errors, err)
+	msg := fmt.Sprintf("expected %s, got %s", describeTokenType(t), describeToken(p.peekToken))
+	p.report(&Diagnostic{
+		Severity: SeverityError,
+		Pos:      p.peekToken.Pos,
+		End:      p.peekToken.End,
+		Message:  msg,
+		Expected: t,
+		Actual:   p.peekToken,
+		Hint:     hintFor(t, p.currentToken),
+	})
+}
+
+func (p *Parser) noPrefixParserError() {
+	tok := p.currentToken
+	hint := "expected an expression here"
+	if tok.Type == token.EOF {
+		hint = "the input ended in the middle of an expression"
+	}
+	p.report(&Diagnostic{
+		Severity: SeverityError,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Message:  fmt.Sprintf("unexpected %s", describeToken(tok)),
+		Actual:   tok,
+		Hint:     hint,
+	})
+}
+
+func (p *Parser) errorAt(tok token.Token, msg, hint string) {
+	p.report(&Diagnostic{
+		Severity: SeverityError,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Message:  msg,
+		Actual:   tok,
+		Hint:     hint,
+	})
+}
+
+// Records a diagnostic unless we are already recovering from an earlier error in same statement
+func (p *Parser) report(d *Diagnostic) {
+	if p.panicking {
+		return
+	}
+	p.errors = append(p.errors, d)
+	p.panicking = true
+}
+
+// synchronize skips tokens till a statement boundary at the given brace depth, that is a ';',
+// a token just before 'let' or 'return', or the '}' closing the enclosing block. Braces opened
+// while skipping are skipped along with their contents. It leaves the parser on the last token
+// of the broken statement (or on the '}'), so the statement loop can continue as usual.
+func (p *Parser) synchronize(depth int) {
+	p.panicking = false
+	for {
+		switch {
+		case p.isCurToken(token.EOF):
+			return
+		case p.isCurToken(token.RBRACE) && p.depth < depth:
+			return
+		case p.depth == depth && p.isCurToken(token.SEMICOLON):
+			return
+		case p.depth == depth && (p.isPeekToken(token.LET) || p.isPeekToken(token.RETURN)):
+			return
+		}
+		p.nextToken()
+	}
+}
+
+// Suggestion shown for a missing token
+func hintFor(expected token.TokenType, after token.Token) string {
+	switch expected {
+	case token.RPAREN:
+		return "did you forget a closing ')'?"
+	case token.RBRACKET:
+		return "did you forget a closing ']'?"
+	case token.RBRACE:
+		return "did you forget a closing '}'?"
+	case token.IDEN:
+		if after.Type == token.LET {
+			return "'let' must be followed by a variable name"
+		}
+		return "expected a name here"
+	case token.ASSIGN:
+		return "a let statement looks like: let <name> = <expression>;"
+	case token.COLON:
+		return "hash entries are written as key: value"
+	case token.IN:
+		return "a for loop looks like: for (<name> in <expression>) { ... }"
+	}
+	return ""
 }
 
 func (p *Parser) peekPrecedence() int {
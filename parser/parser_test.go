@@ -2,6 +2,8 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ShivankSharma070/go-compiler/ast"
@@ -94,6 +96,73 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	tests := []string{"9223372036854775808", "123456789012345678901234567890"}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkForParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not have enough statements, got %d", len(program.Statements))
+		}
+
+		expStmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("statement is not ast.ExpressionStatement, got %T", program.Statements[0])
+		}
+
+		lit, ok := expStmt.Expression.(*ast.BigIntegerLiteral)
+		if !ok {
+			t.Fatalf("expression is not ast.BigIntegerLiteral, got %T", expStmt.Expression)
+		}
+		expected, _ := new(big.Int).SetString(input, 0)
+		if lit.Value.Cmp(expected) != 0 {
+			t.Errorf("lit.Value is not %s, got %s", expected, lit.Value)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"1e9", 1e9},
+		{"2.5e-3", 0.0025},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkForParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not have enough statements, got %d", len(program.Statements))
+		}
+
+		expStmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("statement is not ast.ExpressionStatement, got %T", program.Statements[0])
+		}
+
+		lit, ok := expStmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("expression is not ast.FloatLiteral, got %T", expStmt.Expression)
+		}
+		if lit.Value != tt.expected {
+			t.Errorf("lit.Value is not %g, got %g", tt.expected, lit.Value)
+		}
+		if lit.TokenLiteral() != tt.input {
+			t.Errorf("lit.TokenLiteral is not %q, got %q", tt.input, lit.TokenLiteral())
+		}
+	}
+}
+
 func TestPrefixExpressionParsing(t *testing.T) {
 	tests := []struct {
 		input        string
@@ -104,6 +173,7 @@ func TestPrefixExpressionParsing(t *testing.T) {
 		{"-15;", "-", 15},
 		{"!true;", "!", true},
 		{"!false;", "!", false},
+		{"~5;", "~", 5},
 	}
 
 	for _, tt := range tests {
@@ -156,6 +226,16 @@ func TestInfixExpressionParsing(t *testing.T) {
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
+		{"true && false", true, "&&", false},
+		{"true || false", true, "||", false},
+		{"5<=5", 5, "<=", 5},
+		{"5>=5", 5, ">=", 5},
+		{"5%5", 5, "%", 5},
+		{"5&5", 5, "&", 5},
+		{"5|5", 5, "|", 5},
+		{"5^5", 5, "^", 5},
+		{"5<<5", 5, "<<", 5},
+		{"5>>5", 5, ">>", 5},
 	}
 
 	for _, tt := range tests {
@@ -292,6 +372,42 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"x = y + 1 * 2",
+			"(x = (y + (1 * 2)))",
+		},
+		{
+			"a = b = c == d",
+			"(a = (b = (c == d)))",
+		},
+		{
+			"f(x = 1)",
+			"f((x = 1))",
+		},
+		{
+			"a || b && c || d",
+			"((a || (b && c)) || d)",
+		},
+		{
+			"a < b && !c == d",
+			"((a < b) && ((!c) == d))",
+		},
+		{
+			"x = a || b",
+			"(x = (a || b))",
+		},
+		{
+			"a & b == c | d",
+			"((a & b) == (c | d))",
+		},
+		{
+			"a + b << 2 ^ ~c % 3",
+			"((a + (b << 2)) ^ ((~c) % 3))",
+		},
+		{
+			"a <= b >= c",
+			"((a <= b) >= c)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -309,6 +425,96 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestAssignExpressionParsing(t *testing.T) {
+	l := lexer.New("counter = counter + 1;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkForParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has wrong number of statements. want=1, got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement, got %T", program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("expression is not ast.AssignExpression, got %T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Name, "counter") {
+		return
+	}
+	testInfixExpression(t, exp.Value, "counter", "+", 1)
+}
+
+func TestWhileStatementParsing(t *testing.T) {
+	l := lexer.New("while (x < 10) { x = x + 1; continue; break; }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkForParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has wrong number of statements. want=1, got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.WhileStatement, got %T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body has wrong number of statements. want=3, got=%d", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Errorf("statement is not ast.ContinueStatement, got %T", stmt.Body.Statements[1])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.BreakStatement); !ok {
+		t.Errorf("statement is not ast.BreakStatement, got %T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatementParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		key      string
+		value    string
+		expected string
+	}{
+		{"for (x in [1, 2]) { x }", "", "x", "for (x in [1, 2]) x"},
+		{"for (k, v in h) { if (k) { break; } }", "k", "v", "for (k, v in h) if k break;"},
+		{"for (x in xs) { for (y in ys) { continue; } }", "", "x", "for (x in xs) for (y in ys) continue;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkForParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has wrong number of statements. want=1, got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("statement is not ast.ForStatement, got %T", program.Statements[0])
+		}
+		if tt.key == "" && stmt.Key != nil {
+			t.Errorf("expected no key variable, got %s", stmt.Key)
+		}
+		if tt.key != "" && !testIdentifier(t, stmt.Key, tt.key) {
+			return
+		}
+		if !testIdentifier(t, stmt.Value, tt.value) {
+			return
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong String(). want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
 func TestBoolExpressionParsing(t *testing.T) {
 	input := `false;`
 	l := lexer.New(input)
@@ -719,6 +925,53 @@ func TestParsingIndexExpression(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:2]", "(a[1:2])"},
+		{"a[:n - 1]", "(a[:(n - 1)])"},
+		{"a[-2:]", "(a[(-2):])"},
+		{"a[:]", "(a[:])"},
+		{"a[1:][0]", "((a[1:])[0])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkForParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program. want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestParsingIndexAssignment(t *testing.T) {
+	l := lexer.New("h[k] = a[0] = 1")
+	p := New(l)
+	program := p.ParseProgram()
+	checkForParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.IndexAssignExpression)
+	if !ok {
+		t.Fatalf("expression is not ast.IndexAssignExpression, got %T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Target.Left, "h") || !testIdentifier(t, exp.Target.Index, "k") {
+		return
+	}
+	inner, ok := exp.Value.(*ast.IndexAssignExpression)
+	if !ok {
+		t.Fatalf("value is not ast.IndexAssignExpression, got %T", exp.Value)
+	}
+	if inner.String() != "((a[0]) = 1)" {
+		t.Errorf("wrong inner assignment, got %q", inner.String())
+	}
+}
+
 func TestParsingHashLiterals(t *testing.T) {
 	input := `{"one":1, "two":2, "three":3}`
 	l := lexer.New(input)
@@ -951,3 +1204,169 @@ func checkForParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b;
+};
+add(1, [2, 3][0]);
+if (x) { {"k": 1} } else { !y }`
+
+	l := lexer.NewWithFilename("pos.mk", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkForParserErrors(t, p)
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	fn := letStmt.Value.(*ast.FunctionExpression)
+	sum := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Argument[1]
+	ifExp := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.IfElseExpression)
+
+	tests := []struct {
+		node  ast.Node
+		start string
+		end   string
+	}{
+		{program, "pos.mk:1:1", "pos.mk:5:32"},
+		{letStmt, "pos.mk:1:1", "pos.mk:3:2"},
+		{fn, "pos.mk:1:11", "pos.mk:3:2"},
+		{fn.Body, "pos.mk:1:20", "pos.mk:3:2"},
+		{sum, "pos.mk:2:3", "pos.mk:2:8"},
+		{call, "pos.mk:4:1", "pos.mk:4:18"},
+		{index, "pos.mk:4:8", "pos.mk:4:17"},
+		{ifExp, "pos.mk:5:1", "pos.mk:5:32"},
+		{ifExp.Consequence.Statements[0], "pos.mk:5:10", "pos.mk:5:18"},
+		{ifExp.Alternative.Statements[0], "pos.mk:5:28", "pos.mk:5:30"},
+	}
+
+	for i, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.start {
+			t.Errorf("tests[%d] (%T) wrong start position. want=%s, got=%s", i, tt.node, tt.start, got)
+		}
+		if got := tt.node.End().String(); got != tt.end {
+			t.Errorf("tests[%d] (%T) wrong end position. want=%s, got=%s", i, tt.node, tt.end, got)
+		}
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let = 5;", []string{`1:5: error: expected identifier, got "="`}},
+		{"let x 5;", []string{`1:7: error: expected "=", got integer 5`}},
+		{"add(1, 2;", []string{`1:9: error: expected ")", got ";"`}},
+		{"let x = ;", []string{`1:9: error: unexpected ";"`}},
+		{"1 + ", []string{`1:5: error: unexpected end of input`}},
+		// Only one error per broken statement
+		{"let x = (1 + 2 * ; let y = 2; y", []string{`1:18: error: unexpected ";"`}},
+		{"let a = [1, 2; let b = fn(x) { x + }; let c = 3 c", []string{
+			`1:14: error: expected "]", got ";"`,
+			`1:36: error: unexpected "}"`,
+		}},
+		{"if (x { 1 } let y = 2;", []string{`1:7: error: expected ")", got "{"`}},
+		{"let x = 5 @ 3;", []string{`1:11: error: unexpected illegal character "@"`}},
+		{"let f = fn() { let h = {1: }; h }; f", []string{`1:28: error: unexpected "}"`}},
+		{"} let x = ; x", []string{`1:1: error: unexpected "}"`, `1:11: error: unexpected ";"`}},
+		{"1 = 2; x = 1", []string{`1:3: error: cannot assign to 1`}},
+		{"a + b = 2", []string{`1:7: error: cannot assign to (a + b)`}},
+		{"a[1:] = 2", []string{`1:7: error: cannot assign to (a[1:])`}},
+		{"a[1:2:3]", []string{`1:6: error: expected "]", got ":"`}},
+		{"break; let x = 1; continue", []string{`1:1: error: break outside of a loop`, `1:19: error: continue outside of a loop`}},
+		{"while (true) { fn() { break; } }", []string{`1:23: error: break outside of a loop`}},
+		{"for (x of xs) { x }", []string{`1:8: error: expected "in", got identifier "of"`}},
+		{"for (1 in xs) { x }", []string{`1:6: error: expected identifier, got integer 1`}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("input %q: wrong number of errors. want=%q, got=%q", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, err := range errors {
+			if err != tt.expected[i] {
+				t.Errorf("input %q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], err)
+			}
+		}
+	}
+}
+
+func TestParserRecoversAfterError(t *testing.T) {
+	input := `let x = ; let y = 10; return y;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("expected 1 error, got %q", p.Errors())
+	}
+
+	// The broken let is kept with a nil value, following statements must parse as usual
+	if len(program.Statements) != 3 {
+		t.Fatalf("wrong number of statements. want=3, got=%d", len(program.Statements))
+	}
+	if !testLetStatement(t, program.Statements[1], "y") {
+		return
+	}
+	if _, ok := program.Statements[2].(*ast.ReturnStatement); !ok {
+		t.Fatalf("statement is not ast.ReturnStatement, got %T", program.Statements[2])
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	input := "let total = add(1,\n\tfoo bar);"
+
+	l := lexer.NewWithFilename("sum.mk", input)
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d", len(diagnostics))
+	}
+
+	d := diagnostics[0]
+	if d.Expected != ")" || d.Actual.Literal != "bar" {
+		t.Errorf("wrong expected/actual tokens, got %q/%q", d.Expected, d.Actual.Literal)
+	}
+
+	expected := "sum.mk:2:6: error: expected \")\", got identifier \"bar\"\n" +
+		"    \tfoo bar);\n" +
+		"    \t    ^^^\n" +
+		"hint: did you forget a closing ')'?\n"
+	if got := d.Render(input); got != expected {
+		t.Errorf("wrong render.\nwant=%q\ngot= %q", expected, got)
+	}
+}
+
+func TestDiagnosticRenderUnicode(t *testing.T) {
+	input := `let größe = "日本" + );`
+
+	l := lexer.NewWithFilename("sum.mk", input)
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) == 0 {
+		t.Fatalf("expected a diagnostic, got none")
+	}
+
+	// Columns count characters, so the caret lines up under multi-byte text
+	expected := "sum.mk:1:20: error: "
+	got := diagnostics[0].Render(input)
+	if !strings.HasPrefix(got, expected) {
+		t.Fatalf("wrong render.\nwant prefix=%q\ngot= %q", expected, got)
+	}
+	if !strings.Contains(got, "\n    "+strings.Repeat(" ", 19)+"^\n") {
+		t.Errorf("caret not under ')', got %q", got)
+	}
+}
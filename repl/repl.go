@@ -37,8 +37,8 @@ func Start(in io.Reader, out io.Writer) {
 		p := parser.New(l)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if len(p.Diagnostics()) != 0 {
+			printParserErrors(out, line, p.Diagnostics())
 			continue
 		}
 
@@ -55,7 +55,11 @@ func Start(in io.Reader, out io.Writer) {
 		machine := vm.NewWithGlobalState(code, global)
 		err = machine.Run()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed: \n%s \n", err)
+			if rtErr, ok := err.(*vm.RuntimeError); ok {
+				fmt.Fprintf(out, "Woops! Executing bytecode failed: \n%s", rtErr.StackTrace())
+			} else {
+				fmt.Fprintf(out, "Woops! Executing bytecode failed: \n%s \n", err)
+			}
 			continue
 		}
 
@@ -78,11 +82,9 @@ const MONKEY_FACE = `            __,__
            '-----'
 `
 
-func printParserErrors(out io.Writer, errors []string) {
+func printParserErrors(out io.Writer, source string, diagnostics []*parser.Diagnostic) {
 	io.WriteString(out, MONKEY_FACE)
 	io.WriteString(out, "Woops! We ran into some monkey business here.\n")
 	io.WriteString(out, "Parser errors:\n")
-	for _, err := range errors {
-		io.WriteString(out, "\t"+err+"\n")
-	}
+	io.WriteString(out, parser.RenderDiagnostics(source, diagnostics))
 }
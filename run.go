@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ShivankSharma070/go-compiler/ast"
+	"github.com/ShivankSharma070/go-compiler/compiler"
+	"github.com/ShivankSharma070/go-compiler/evaluator"
+	"github.com/ShivankSharma070/go-compiler/lexer"
+	"github.com/ShivankSharma070/go-compiler/object"
+	"github.com/ShivankSharma070/go-compiler/parser"
+	"github.com/ShivankSharma070/go-compiler/vm"
+)
+
+// Exit status of the commands
+const (
+	exitOK    = 0
+	exitError = 1 // Parse, compile or runtime error in the script
+	exitUsage = 2 // Bad command line
+)
+
+// runCommand implements `run [--engine=vm|eval] [--overflow=promote|error] [-O level] <file>` and
+// returns the process exit status. The file can be Monkey source or a bytecode file written by the
+// build command.
+func runCommand(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	engine := flags.String("engine", "vm", "use 'vm' or 'eval'")
+	overflow := flags.String("overflow", "promote", "integer overflow gives a big integer with 'promote' or fails with 'error', vm only")
+	optimization := optimizationFlag(flags)
+	files, err := parseFlags(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if !validOptimizationLevel(*optimization) {
+		return exitUsage
+	}
+
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "run expects exactly one file, got %d\n\n%s", len(files), usage)
+		return exitUsage
+	}
+	if *engine != "vm" && *engine != "eval" {
+		fmt.Fprintf(os.Stderr, "unknown engine %q, use 'vm' or 'eval'\n", *engine)
+		return exitUsage
+	}
+	config := vm.DefaultConfig()
+	switch *overflow {
+	case "promote":
+		config.Overflow = vm.OverflowPromote
+	case "error":
+		config.Overflow = vm.OverflowError
+	default:
+		fmt.Fprintf(os.Stderr, "unknown overflow mode %q, use 'promote' or 'error'\n", *overflow)
+		return exitUsage
+	}
+	if *engine == "eval" && config.Overflow != vm.OverflowPromote {
+		fmt.Fprintf(os.Stderr, "the eval engine always promotes integers which overflow\n")
+		return exitUsage
+	}
+
+	filename, data, err := readInput(files[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	if compiler.IsBytecodeFile(data) {
+		if *engine != "vm" {
+			fmt.Fprintf(os.Stderr, "%s: bytecode files can only be run with the vm engine\n", filename)
+			return exitUsage
+		}
+		bytecode, err := compiler.ReadBytecode(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+			return exitError
+		}
+		// Files can come from anywhere, never hand the vm bytecode it could choke on
+		err = vm.Verify(bytecode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+			return exitError
+		}
+		return runBytecode(bytecode, config)
+	}
+
+	program, ok := parseSource(filename, string(data))
+	if !ok {
+		return exitError
+	}
+
+	if *engine == "eval" {
+		result := evaluator.Eval(program, object.NewEnvironment())
+		if errObj, ok := result.(*object.Error); ok {
+			fmt.Fprintf(os.Stderr, "%s: runtime error: %s\n", errObj.Pos, errObj.Message)
+			return exitError
+		}
+		return exitOK
+	}
+
+	comp, ok := compileProgram(filename, program, *optimization)
+	if !ok {
+		return exitError
+	}
+	return runBytecode(comp.Bytecode(), config)
+}
+
+func runBytecode(bytecode *compiler.Bytecode, config vm.Config) int {
+	machine := vm.NewWithConfig(bytecode, config)
+	err := machine.Run()
+	if err != nil {
+		if rtErr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(os.Stderr, rtErr.StackTrace())
+		} else {
+			fmt.Fprintf(os.Stderr, "runtime error: %s\n", err)
+		}
+		return exitError
+	}
+
+	return exitOK
+}
+
+// Parses source, printing diagnostics to stderr if there are any
+func parseSource(filename, source string) (*ast.Program, bool) {
+	source = stripShebang(source)
+
+	l := lexer.NewWithFilename(filename, source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		fmt.Fprint(os.Stderr, parser.RenderDiagnostics(source, p.Diagnostics()))
+		return nil, false
+	}
+	return program, true
+}
+
+// Parses args with flags, which unlike flags.Parse accepts flags after the file as well as
+// before it, so `run prog.mk --engine=eval` works. Returns the arguments which are not flags,
+// everything after "--" is one.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// Registers the -O flag shared by every command which compiles source
+func optimizationFlag(flags *flag.FlagSet) *int {
+	return flags.Int("O", int(compiler.OptimizeNone), fmt.Sprintf("optimization level, 0 to %d", compiler.OptimizePeephole))
+}
+
+func validOptimizationLevel(level int) bool {
+	if level < int(compiler.OptimizeNone) || level > int(compiler.OptimizePeephole) {
+		fmt.Fprintf(os.Stderr, "unknown optimization level %d, use 0 to %d\n", level, compiler.OptimizePeephole)
+		return false
+	}
+	return true
+}
+
+// Compiles program, printing the error to stderr if compilation fails
+func compileProgram(filename string, program *ast.Program, optimization int) (*compiler.Compiler, bool) {
+	comp := compiler.New()
+	comp.SetOptimizationLevel(compiler.OptimizationLevel(optimization))
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: compile error: %s\n", filename, err)
+		return nil, false
+	}
+	return comp, true
+}
+
+// Reads the file at path, or stdin when path is "-"
+func readInput(path string) (string, []byte, error) {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		return "<stdin>", data, err
+	}
+
+	data, err := os.ReadFile(path)
+	return path, data, err
+}
+
+// A leading #! line is blanked out, keeping its newline so that positions of the remaining
+// lines are unchanged.
+func stripShebang(source string) string {
+	if !strings.HasPrefix(source, "#!") {
+		return source
+	}
+	if newline := strings.IndexByte(source, '\n'); newline >= 0 {
+		return source[newline:]
+	}
+	return ""
+}
@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
@@ -9,6 +11,7 @@ const (
 	// Identifier and Literals
 	IDEN = "IDEN" // Variable names
 	INT  = "INT"
+	FLOAT = "FLOAT"
 	STRING = "STRING"
 
 	// Operators
@@ -20,9 +23,23 @@ const (
 	SLASH   = "/"
 	GT      = ">"
 	LT      = "<"
+	PERCENT = "%"
 
 	EQ     = "=="
 	NOT_EQ = "!="
+	LT_EQ  = "<="
+	GT_EQ  = ">="
+
+	// Bitwise operators
+	AMPERSAND = "&"
+	PIPE      = "|"
+	CARET     = "^"
+	TILDE     = "~"
+	SHL       = "<<"
+	SHR       = ">>"
+
+	AND = "&&"
+	OR  = "||"
 
 	//Delimeters
 	SEMICOLON = ";"
@@ -45,11 +62,45 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 )
 
 type Token struct {
 	Type    TokenType
 	Literal string
+
+	Pos Position // Position of the first character of the token
+	End Position // Position immediately after the last character of the token
+}
+
+// Position describes a location in the source. Offset is a 0 based byte offset,
+// Line and Column start from 1. A Position with Line == 0 is considered invalid.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns position in the form file:line:column, file is omitted when empty
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 var keyword = map[string]TokenType{
@@ -60,6 +111,11 @@ var keyword = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 func LookUpIden(iden string) TokenType {
@@ -69,6 +125,6 @@ func LookUpIden(iden string) TokenType {
 	return IDEN
 }
 
-func NewToken(t TokenType, char byte) Token {
-	return Token{t, string(char)}
+func NewToken(t TokenType, char rune) Token {
+	return Token{Type: t, Literal: string(char)}
 }
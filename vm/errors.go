@@ -0,0 +1,65 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ShivankSharma070/go-compiler/token"
+)
+
+// StackFrame describes one active function call at the time of a runtime error
+type StackFrame struct {
+	Function string
+	Pos      token.Position
+}
+
+func (sf StackFrame) String() string {
+	return fmt.Sprintf("%s (%s)", sf.Function, sf.Pos)
+}
+
+// RuntimeError is returned by VM.Run when execution fails. Frames holds the call stack at the
+// point of failure, innermost call first.
+type RuntimeError struct {
+	Message string
+	Frames  []StackFrame
+}
+
+func (e *RuntimeError) Error() string { return e.Message }
+
+// Pos returns the source position where the error happened, if known
+func (e *RuntimeError) Pos() token.Position {
+	if len(e.Frames) == 0 {
+		return token.Position{}
+	}
+	return e.Frames[0].Pos
+}
+
+// StackTrace formats the error along with the call stack. Runs of identical frames, as left
+// behind by deep recursion, are collapsed into a single line.
+func (e *RuntimeError) StackTrace() string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "runtime error: %s\n", e.Message)
+	for i := 0; i < len(e.Frames); {
+		frame := e.Frames[i]
+		fmt.Fprintf(&out, "    at %s\n", frame)
+
+		repeated := 0
+		for i+1+repeated < len(e.Frames) && e.Frames[i+1+repeated] == frame {
+			repeated++
+		}
+		if repeated > 0 {
+			fmt.Fprintf(&out, "    ... repeated %d more times\n", repeated)
+		}
+		i += 1 + repeated
+	}
+	return out.String()
+}
+
+// Builds a RuntimeError from current state of the call stack
+func (vm *VM) newRuntimeError(msg string) *RuntimeError {
+	frames := make([]StackFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frames = append(frames, vm.frames[i].stackFrame())
+	}
+	return &RuntimeError{Message: msg, Frames: frames}
+}
@@ -3,6 +3,7 @@ package vm
 import (
 	"github.com/ShivankSharma070/go-compiler/code"
 	"github.com/ShivankSharma070/go-compiler/object"
+	"github.com/ShivankSharma070/go-compiler/token"
 )
 
 type Frame struct {
@@ -21,3 +22,24 @@ func NewFrame(c *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.c.Fn.Instructions
 }
+
+// Name of the function running in this frame, as shown in stack traces
+func (f *Frame) FunctionName() string {
+	if f.c.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return f.c.Fn.Name
+}
+
+// Source position of the instruction currently being executed in this frame
+func (f *Frame) Pos() token.Position {
+	ip := f.ip
+	if ip < 0 {
+		ip = 0
+	}
+	return f.c.Fn.Lines.PositionFor(ip)
+}
+
+func (f *Frame) stackFrame() StackFrame {
+	return StackFrame{Function: f.FunctionName(), Pos: f.Pos()}
+}
@@ -0,0 +1,229 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ShivankSharma070/go-compiler/code"
+	"github.com/ShivankSharma070/go-compiler/compiler"
+	"github.com/ShivankSharma070/go-compiler/object"
+)
+
+// VerifyError describes why bytecode was rejected by Verify
+type VerifyError struct {
+	Function string // Name of the function, MainFunctionName for top level instructions
+	Constant int    // Index of the function in the constant pool, -1 for top level instructions
+	Offset   int
+	Message  string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Constant < 0 {
+		return fmt.Sprintf("invalid bytecode in %s at offset %04d: %s", e.Function, e.Offset, e.Message)
+	}
+	return fmt.Sprintf("invalid bytecode in %s (constant %d) at offset %04d: %s", e.Function, e.Constant, e.Offset, e.Message)
+}
+
+// Verify checks bytecode before it is run, so that malformed or hand crafted bytecode is
+// reported as an error instead of panicking or misbehaving inside Run. For the top level
+// instructions and every compiled function in the constant pool it checks
+//   - instructions are well formed and jumps land on instruction boundaries
+//   - constant, local, free variable and builtin indices are in range
+//   - OpClosure refers to a compiled function
+//   - the stack never underflows and has the same depth wherever control flow merges,
+//     which bounds the stack used by each function
+//   - functions always end with a return
+//
+// A return in the top level instructions is allowed, it ends the program as a top level return
+// statement does. Bytecode produced by the compiler always passes, there is no need to verify it.
+func Verify(bc *compiler.Bytecode) error {
+	// Number of free variables each function is closed over, from its OpClosure instructions
+	numFree := map[int]int{}
+
+	main := &object.CompiledFunction{Instructions: bc.Instructions, Name: MainFunctionName}
+	functions := []*functionVerifier{{fn: main, constant: -1}}
+	for i, constant := range bc.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			functions = append(functions, &functionVerifier{fn: fn, constant: i})
+		}
+	}
+
+	for _, f := range functions {
+		f.constants = bc.Constants
+		f.numFree = numFree
+		err := f.verify()
+		if err != nil {
+			return err
+		}
+	}
+
+	// OpGetFree can only be checked once every closure site is known
+	for _, f := range functions {
+		if f.constant < 0 {
+			if f.maxFree >= 0 {
+				return f.errorAt(f.maxFreeOffset, "top level instructions have no free variables")
+			}
+			continue
+		}
+		if f.maxFree >= 0 && f.maxFree >= numFree[f.constant] {
+			return f.errorAt(f.maxFreeOffset, fmt.Sprintf("free variable %d out of range, function is closed over %d", f.maxFree, numFree[f.constant]))
+		}
+	}
+
+	return nil
+}
+
+type functionVerifier struct {
+	fn        *object.CompiledFunction
+	constant  int
+	constants []object.Object
+	numFree   map[int]int
+
+	maxFree       int // Highest OpGetFree operand, -1 if there are none
+	maxFreeOffset int
+}
+
+func (f *functionVerifier) errorAt(offset int, msg string) *VerifyError {
+	name := f.fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	return &VerifyError{Function: name, Constant: f.constant, Offset: offset, Message: msg}
+}
+
+func (f *functionVerifier) verify() error {
+	ins := f.fn.Instructions
+	f.maxFree = -1
+
+	err := code.Verify(ins)
+	if err != nil {
+		verr := err.(*code.VerifyError)
+		return f.errorAt(verr.Offset, verr.Message)
+	}
+
+	if f.fn.NumParameters > f.fn.NumLocals {
+		return f.errorAt(0, fmt.Sprintf("%d parameters do not fit in %d locals", f.fn.NumParameters, f.fn.NumLocals))
+	}
+
+	for offset := 0; offset < len(ins); {
+		op, operands, width, _ := code.ReadInstruction(ins, offset)
+		err := f.verifyOperands(offset, op, operands)
+		if err != nil {
+			return err
+		}
+		offset += width
+	}
+
+	return f.verifyStack()
+}
+
+func (f *functionVerifier) verifyOperands(offset int, op code.Opcode, operands []int) error {
+	switch op {
+	case code.OpConstant:
+		if operands[0] >= len(f.constants) {
+			return f.errorAt(offset, fmt.Sprintf("constant %d out of range, pool has %d", operands[0], len(f.constants)))
+		}
+
+	case code.OpClosure:
+		index, free := operands[0], operands[1]
+		if index >= len(f.constants) {
+			return f.errorAt(offset, fmt.Sprintf("constant %d out of range, pool has %d", index, len(f.constants)))
+		}
+		if _, ok := f.constants[index].(*object.CompiledFunction); !ok {
+			return f.errorAt(offset, fmt.Sprintf("OpClosure refers to constant %d of type %s, not a compiled function", index, f.constants[index].Type()))
+		}
+		if previous, ok := f.numFree[index]; ok && previous != free {
+			return f.errorAt(offset, fmt.Sprintf("function %d is closed over %d free variables here, %d elsewhere", index, free, previous))
+		}
+		f.numFree[index] = free
+
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpSetGlobalCell:
+		if operands[0] >= GlobalSize {
+			return f.errorAt(offset, fmt.Sprintf("global %d out of range, there are %d", operands[0], GlobalSize))
+		}
+
+	case code.OpGetLocal, code.OpSetLocal, code.OpSetLocalCell:
+		if operands[0] >= f.fn.NumLocals {
+			return f.errorAt(offset, fmt.Sprintf("local %d out of range, function has %d", operands[0], f.fn.NumLocals))
+		}
+
+	case code.OpGetBuiltin:
+		if operands[0] >= len(object.Builtins) {
+			return f.errorAt(offset, fmt.Sprintf("builtin %d out of range, there are %d", operands[0], len(object.Builtins)))
+		}
+
+	case code.OpIterNext:
+		if operands[1] < 1 || operands[1] > 2 {
+			return f.errorAt(offset, fmt.Sprintf("OpIterNext takes 1 or 2 loop variables, got %d", operands[1]))
+		}
+
+	case code.OpGetFree, code.OpSetFree:
+		if operands[0] > f.maxFree {
+			f.maxFree = operands[0]
+			f.maxFreeOffset = offset
+		}
+	}
+
+	return nil
+}
+
+// Follows every path through the instructions, tracking how many values are on the stack
+func (f *functionVerifier) verifyStack() error {
+	ins := f.fn.Instructions
+	depths := make([]int, len(ins)+1)
+	for i := range depths {
+		depths[i] = -1 // Not reached yet
+	}
+
+	pending := []int{0}
+	depths[0] = 0
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		depth := depths[offset]
+
+		if offset == len(ins) {
+			if f.constant >= 0 {
+				return f.errorAt(offset, "function can end without returning a value")
+			}
+			continue
+		}
+
+		op, operands, width, _ := code.ReadInstruction(ins, offset)
+		pops, pushes := code.StackEffect(op, operands)
+		if depth < pops {
+			def, _ := code.Lookup(op)
+			return f.errorAt(offset, fmt.Sprintf("stack underflow, %s needs %d values, stack has %d", def.Name, pops, depth))
+		}
+		depth = depth - pops + pushes
+
+		// Offsets control can go to next, along with the stack depth there
+		type successor struct{ offset, depth int }
+		successors := []successor{}
+		switch op {
+		case code.OpReturn, code.OpReturnValue:
+		case code.OpJump:
+			successors = append(successors, successor{operands[0], depth})
+		case code.OpJumpNotTruthy:
+			successors = append(successors, successor{offset + width, depth}, successor{operands[0], depth})
+		case code.OpIterNext:
+			// The loop variables are only pushed if the jump is not taken
+			successors = append(successors, successor{offset + width, depth}, successor{operands[0], depth - operands[1]})
+		default:
+			successors = append(successors, successor{offset + width, depth})
+		}
+
+		for _, next := range successors {
+			switch depths[next.offset] {
+			case -1:
+				depths[next.offset] = next.depth
+				pending = append(pending, next.offset)
+			case next.depth:
+			default:
+				return f.errorAt(next.offset, fmt.Sprintf("inconsistent stack depth, %d on one path and %d on another", depths[next.offset], next.depth))
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package vm
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/ShivankSharma070/go-compiler/code"
 	"github.com/ShivankSharma070/go-compiler/compiler"
@@ -9,9 +10,16 @@ import (
 )
 
 const StackSize = 2048
-const GlobalSize = 65536
+const GlobalSize = compiler.MaxGlobals
 const MaxFrames = 1024
 
+// Stack and frames start this small and grow on demand till the configured limits
+const initialStackSize = 256
+const initialFrames = 64
+
+// Name of the frame running top level instructions, as shown in stack traces
+const MainFunctionName = "<main>"
+
 var (
 	True  = &object.Boolean{Value: true}
 	False = &object.Boolean{Value: false}
@@ -27,6 +35,29 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int // Point to next free slot for new frame
+
+	config Config
+}
+
+// Config holds limits of a single VM. Zero values are replaced by defaults.
+type Config struct {
+	StackSize int // Maximum number of values on the stack
+	MaxFrames int // Maximum depth of nested function calls
+
+	Overflow OverflowMode // What integer arithmetic does when a result does not fit in an int64
+}
+
+type OverflowMode int
+
+const (
+	// OverflowPromote gives a big integer, with as many bits as the result needs
+	OverflowPromote OverflowMode = iota
+	// OverflowError stops the program with an integer overflow error
+	OverflowError
+)
+
+func DefaultConfig() Config {
+	return Config{StackSize: StackSize, MaxFrames: MaxFrames}
 }
 
 func NewWithGlobalState(bc *compiler.Bytecode, global []object.Object) *VM {
@@ -36,20 +67,33 @@ func NewWithGlobalState(bc *compiler.Bytecode, global []object.Object) *VM {
 }
 
 func New(bc *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bc.Instructions}
+	return NewWithConfig(bc, DefaultConfig())
+}
+
+func NewWithConfig(bc *compiler.Bytecode, config Config) *VM {
+	defaults := DefaultConfig()
+	if config.StackSize <= 0 {
+		config.StackSize = defaults.StackSize
+	}
+	if config.MaxFrames <= 0 {
+		config.MaxFrames = defaults.MaxFrames
+	}
+
+	mainFn := &object.CompiledFunction{Instructions: bc.Instructions, Name: MainFunctionName, Lines: bc.Lines}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, min(initialFrames, config.MaxFrames))
 	frames[0] = mainFrame
 
 	return &VM{
 		constants:   bc.Constants,
-		stack:       make([]object.Object, StackSize),
+		stack:       make([]object.Object, min(initialStackSize, config.StackSize)),
 		sp:          0,
 		global:      make([]object.Object, GlobalSize),
 		frames:      frames,
 		framesIndex: 1,
+		config:      config,
 	}
 }
 
@@ -57,9 +101,38 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= len(vm.frames) {
+		if vm.framesIndex >= vm.config.MaxFrames {
+			return fmt.Errorf("stack overflow: maximum call depth of %d frames exceeded", vm.config.MaxFrames)
+		}
+		frames := make([]*Frame, min(len(vm.frames)*2, vm.config.MaxFrames))
+		copy(frames, vm.frames)
+		vm.frames = frames
+	}
+
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
+}
+
+// Makes sure the stack has at least size slots, growing it if allowed
+func (vm *VM) ensureStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > vm.config.StackSize {
+		return fmt.Errorf("stack overflow: stack size of %d exceeded", vm.config.StackSize)
+	}
+
+	newSize := len(vm.stack) * 2
+	for newSize < size {
+		newSize *= 2
+	}
+	stack := make([]object.Object, min(newSize, vm.config.StackSize))
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -74,215 +147,261 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
-func (vm *VM) Run() error {
-	var ip int
-	var ins code.Instructions
-	var op code.Opcode
+// Run executes the bytecode. Any error returned is a *RuntimeError carrying the call stack.
+// Go panics raised while running (for example inside a builtin) are recovered and reported as
+// runtime errors as well, so a faulty script can never bring down the host process.
+func (vm *VM) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.newRuntimeError(fmt.Sprintf("internal error: %v", r))
+		}
+	}()
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
+	err = vm.run()
+	if err != nil {
+		return vm.newRuntimeError(err.Error())
+	}
+	return nil
+}
+
+func (vm *VM) run() error {
+	var operands [code.MaxOperands]int
 
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
-		op = code.Opcode(ins[ip])
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		frame := vm.currentFrame()
+		frame.ip++
+
+		// Operands are decoded the same way with or without the OpWide prefix, so every case
+		// below handles both forms. The instruction pointer is left on the last byte of the
+		// instruction, jumps set it to the byte before their target.
+		op, _, width, err := code.DecodeInstruction(frame.Instructions(), frame.ip, operands[:])
+		if err != nil {
+			return err
+		}
+		frame.ip += width - 1
 
 		switch op {
 		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
+			err = vm.push(vm.constants[operands[0]])
 
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.executeBinaryOperation(op)
-			if err != nil {
-				return err
-			}
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+			err = vm.executeBinaryOperation(op)
 
 		case code.OpPop:
 			vm.pop()
 
 		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
+			err = vm.push(True)
 
 		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
+			err = vm.push(False)
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			err := vm.executeComparison(op)
-			if err != nil {
-				return err
-			}
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterEqual:
+			err = vm.executeComparison(op)
 
 		case code.OpMinus:
-			err := vm.executeMinusOperator()
-			if err != nil {
-				return err
-			}
+			err = vm.executeMinusOperator()
+
 		case code.OpBang:
-			err := vm.executeBangOperator()
-			if err != nil {
-				return err
-			}
+			err = vm.executeBangOperator()
+
+		case code.OpBitNot:
+			err = vm.executeBitNotOperator()
 
 		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
+			frame.ip = operands[0] - 1
 
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
+				frame.ip = operands[0] - 1
 			}
+
 		case code.OpNull:
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
+			err = vm.push(Null)
 
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			vm.global[globalIndex] = vm.pop()
+			vm.global[operands[0]] = vm.pop()
 
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.global[globalIndex])
-			if err != nil {
-				return err
-			}
+			err = vm.push(vm.global[operands[0]])
 
 		case code.OpArray:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			numElements := operands[0]
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
-			err := vm.push(array)
-			if err != nil {
-				return err
-			}
+			err = vm.push(array)
 
 		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
+			numElements := operands[0]
+			var hash object.Object
+			hash, err = vm.buildHash(vm.sp-numElements, vm.sp)
+			if err == nil {
+				vm.sp -= numElements
+				err = vm.push(hash)
 			}
-			vm.sp -= numElements
 
-			err = vm.push(hash)
-			if err != nil {
-				return err
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.executeIndexExpression(left, index)
+
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+
+			var result object.Object
+			result, err = object.Slice(left, low, high)
+			if err == nil {
+				err = vm.push(result)
 			}
 
-		case code.OpIndex:
+		case code.OpSetIndex:
+			value := vm.pop()
 			index := vm.pop()
 			left := vm.pop()
 
-			err := vm.executeIndexExpression(left, index)
-			if err != nil {
-				return err
+			err = object.SetIndex(left, index, value)
+			if err == nil {
+				err = vm.push(value)
 			}
 
 		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			err = vm.executeCall(operands[0])
 
-			err := vm.executeCall(int(numArgs))
-			if err != nil {
-				return err
-			}
+		case code.OpTailCall:
+			err = vm.executeTailCall(operands[0])
 
 		case code.OpReturnValue:
 			returnValue := vm.pop()
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-
-			err := vm.push(returnValue)
-			if err != nil {
-				return err
+			if vm.framesIndex == 1 {
+				// A return at top level ends the program, the returned value is left as the
+				// last popped element like the value of a final expression statement
+				return nil
 			}
-
-		case code.OpReturn:
-			frame := vm.popFrame()
+			vm.popFrame()
 			vm.sp = frame.basePointer - 1
+			err = vm.push(returnValue)
 
-			err := vm.push(Null)
-			if err != nil {
+		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				err = vm.push(Null)
+				if err == nil {
+					vm.pop()
+				}
 				return err
 			}
+			vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			err = vm.push(Null)
 
 		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			vm.stack[frame.basePointer+operands[0]] = vm.pop()
 
 		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
-			if err != nil {
-				return err
-			}
+			err = vm.push(vm.stack[frame.basePointer+operands[0]])
 
 		case code.OpGetBuiltin:
-			builinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			definition := object.Builtins[builinIndex]
-
-			err := vm.push(definition.Buitlin)
-			if err != nil {
-				return err
-			}
+			err = vm.push(object.Builtins[operands[0]].Buitlin)
 
 		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
-
-			err := vm.pushClosure(int(constIndex), int(numFree))
-			if err != nil {
-				return err
-			}
+			err = vm.pushClosure(operands[0], operands[1])
 
 		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			err = vm.push(frame.c.Free[operands[0]])
 
-			currentClosure := vm.currentFrame().c
+		case code.OpCurrentClosure:
+			err = vm.push(frame.c)
 
-			err := vm.push(currentClosure.Free[freeIndex])
-			if err != nil {
-				return err
+		case code.OpNewCell:
+			value := vm.pop()
+			err = vm.push(&object.Cell{Value: value})
+
+		case code.OpDeref:
+			var cell *object.Cell
+			cell, err = asCell(vm.pop())
+			if err == nil {
+				err = vm.push(cell.Value)
 			}
 
-		case code.OpCurrentClosure:
-			currentClosure := vm.currentFrame().c
-			err := vm.push(currentClosure)
-			if err != nil {
-				return err
+		case code.OpSetLocalCell:
+			err = vm.setCell(vm.stack[frame.basePointer+operands[0]])
+
+		case code.OpSetFree:
+			err = vm.setCell(frame.c.Free[operands[0]])
+
+		case code.OpSetGlobalCell:
+			err = vm.setCell(vm.global[operands[0]])
+
+		case code.OpIter:
+			iterable := vm.pop()
+			it, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
 			}
+			err = vm.push(it)
+
+		case code.OpIterNext:
+			err = vm.iterNext(operands[0], operands[1])
+
+		default:
+			def, _ := code.Lookup(op)
+			return fmt.Errorf("opcode %s is not implemented by the vm", def.Name)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// Pushes the loop variables for the next iteration of the iterator on top of the stack, or
+// jumps to target once it is done
+func (vm *VM) iterNext(target, variables int) error {
+	it, ok := vm.stack[vm.sp-1].(*object.Iterator)
+	if !ok {
+		return fmt.Errorf("expected an iterator, got %s", vm.stack[vm.sp-1].Type())
+	}
+
+	values, ok := it.Next(variables)
+	if !ok {
+		vm.currentFrame().ip = target - 1
+		return nil
+	}
+	for _, v := range values {
+		err := vm.push(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pops a value and stores it into obj, which has to be a cell
+func (vm *VM) setCell(obj object.Object) error {
+	cell, err := asCell(obj)
+	if err != nil {
+		return err
+	}
+	cell.Value = vm.pop()
+	return nil
+}
+
+// Only bytecode which does not come from the compiler can use something else as a cell
+func asCell(obj object.Object) (*object.Cell, error) {
+	cell, ok := obj.(*object.Cell)
+	if !ok {
+		if obj == nil {
+			return nil, fmt.Errorf("expected a cell, got an unset variable")
+		}
+		return nil, fmt.Errorf("expected a cell, got %s", obj.Type())
+	}
+	return cell, nil
+}
+
 func (vm *VM) pushClosure(index, numFree int) error {
 	constant := vm.constants[index]
 	function, ok := constant.(*object.CompiledFunction)
@@ -309,7 +428,7 @@ func (vm *VM) executeCall(numArgs int) error {
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
 	default:
-		return fmt.Errorf("calling a non-closure and non-built-in")
+		return fmt.Errorf("calling non-function: %s", callee.Type())
 	}
 
 }
@@ -317,6 +436,9 @@ func (vm *VM) executeCall(numArgs int) error {
 func (vm *VM) callBuiltin(fn *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 	result := fn.Fn(args...)
+	if errObj, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", errObj.Message)
+	}
 	vm.sp = vm.sp - numArgs - 1
 	if result != nil {
 		vm.push(result)
@@ -332,7 +454,42 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	err := vm.ensureStack(frame.basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
+	err = vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+// A tail call to a closure replaces the frame of the caller: callee and arguments move down to
+// where the caller's callee and arguments were, and the frame starts over with the new closure.
+// Deep recursion in tail position therefore runs in constant stack, at the cost of the caller
+// missing from stack traces. Builtins, and calls made by top level code which has no frame to
+// give up, run as a regular call followed by the OpReturnValue after OpTailCall.
+func (vm *VM) executeTailCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	cl, ok := callee.(*object.Closure)
+	if !ok || vm.framesIndex == 1 {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	err := vm.ensureStack(frame.basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
+
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.c = cl
+	frame.ip = -1
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 	return nil
 }
@@ -341,6 +498,12 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.ARRAY_OBJ:
+		return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
+	case left.Type() == object.STRING_OBJ:
+		return fmt.Errorf("string index must be INTEGER, got %s", index.Type())
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -350,24 +513,31 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 
 func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	array := left.(*object.Array)
-	i := index.(*object.Integer).Value
-	maxLength := int64(len(array.Elements) - 1)
-
-	if i < 0 || i > maxLength {
+	i, ok := object.ElementIndex(index, len(array.Elements))
+	if !ok {
 		return vm.push(Null)
 	}
 
 	return vm.push(array.Elements[i])
 }
 
+// Strings are indexed by code point, giving a string of one character
+func (vm *VM) executeStringIndex(left, index object.Object) error {
+	char, ok := object.CharAt(left.(*object.String).Value, index)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: char})
+}
+
 func (vm *VM) executeHashIndex(left, index object.Object) error {
 	hash := left.(*object.Hash)
-	key, ok := index.(object.Hashable)
-	if !ok {
+	if _, ok := index.(object.Hashable); !ok {
 		return fmt.Errorf("unusable as a hash key: %s", index.Type())
 	}
 
-	pair, ok := hash.Pair[key.HashKey()]
+	pair, ok := hash.Pairs.Get(index)
 	if !ok {
 		return vm.push(Null)
 	}
@@ -375,20 +545,18 @@ func (vm *VM) executeHashIndex(left, index object.Object) error {
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPair := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
+		if _, ok := key.(object.Hashable); !ok {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
 
-		hashedPair[hashKey.HashKey()] = pair
+		hash.Pairs.Set(key, value)
 	}
-	return &object.Hash{Pair: hashedPair}, nil
+	return hash, nil
 }
 
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
@@ -402,12 +570,24 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
+	if f, ok := operand.(*object.Float); ok {
+		return vm.push(&object.Float{Value: -f.Value})
+	}
 	if operand.Type() != object.INTEGER_OBJ {
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.pushInteger(object.NegateInteger(operand))
+}
+
+func (vm *VM) executeBitNotOperator() error {
+	operand := vm.pop()
+
+	if operand.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("unsupported type for bitwise not: %s", operand.Type())
+	}
+
+	return vm.pushInteger(object.NotInteger(operand))
 }
 
 func (vm *VM) executeBangOperator() error {
@@ -429,35 +609,70 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
+	// Any two values can be compared for equality, the other comparisons need numbers or strings
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
+	}
+
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if leftValue, rightValue, ok := floatOperands(left, right); ok {
+		return vm.executeFloatComparison(op, leftValue, rightValue)
+	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
 
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(left == right))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(left != right))
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), comparisonOperators[op], right.Type())
+}
 
+// Operators of the ordering opcodes. The compiler swaps the operands of < and <=, so errors
+// show those as > and >=.
+var comparisonOperators = map[code.Opcode]string{
+	code.OpGreaterThan:  ">",
+	code.OpGreaterEqual: ">=",
+}
+
+func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
+	cmp := object.CompareIntegers(left, right)
+
+	switch op {
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(cmp > 0))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp >= 0))
 	default:
-		return fmt.Errorf("unkown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return fmt.Errorf("unknown operator: %s", comparisonOperators[op])
 	}
+}
 
+func (vm *VM) executeFloatComparison(op code.Opcode, leftValue, rightValue float64) error {
+	switch op {
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %s", comparisonOperators[op])
+	}
 }
 
-func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+// Strings are ordered lexicographically, byte by byte
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
 
 	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
 	default:
-		return fmt.Errorf("unkown operator: %d", op)
+		return fmt.Errorf("unknown operator: %s", comparisonOperators[op])
 	}
 }
 
@@ -480,30 +695,42 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	case rightType == object.STRING_OBJ && leftType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	}
+	if _, _, ok := floatOperands(left, right); ok {
+		return vm.executeBinaryFloatOperation(op, left, right)
+	}
 
 	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
 }
 
-func (vm *VM) executeBinaryStringOperation(op code.Opcode, left object.Object, right object.Object) error {
-	leftValue := left.(*object.String).Value
-	rightValue := right.(*object.String).Value
+// Numbers where at least one is a float, the other one is converted
+func floatOperands(left, right object.Object) (float64, float64, bool) {
+	leftValue, leftFloat, ok := numberValue(left)
+	if !ok {
+		return 0, 0, false
+	}
+	rightValue, rightFloat, ok := numberValue(right)
+	if !ok || !leftFloat && !rightFloat {
+		return 0, 0, false
+	}
+	return leftValue, rightValue, true
+}
 
-	var result string
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
+func numberValue(obj object.Object) (value float64, isFloat bool, ok bool) {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.BigInteger:
+		return object.IntegerToFloat(obj), false, true
+	case *object.Float:
+		return obj.Value, true, true
 	default:
-		return fmt.Errorf("unkown string operation: %d", op)
+		return 0, false, false
 	}
-
-	return vm.push(&object.String{Value: result})
 }
 
-func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+// Floats follow IEEE 754, dividing by zero gives an infinity or NaN instead of an error
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue, rightValue, _ := floatOperands(left, right)
 
-	var result int64
+	var result float64
 
 	switch op {
 	case code.OpAdd:
@@ -514,17 +741,82 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object,
 		result = leftValue * rightValue
 	case code.OpDiv:
 		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+	}
 
+	return vm.push(&object.Float{Value: result})
+}
+
+func (vm *VM) executeBinaryStringOperation(op code.Opcode, left object.Object, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	var result string
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
 	default:
-		return fmt.Errorf("unkown integer operator: %d", op)
+		def, _ := code.Lookup(op)
+		return fmt.Errorf("unknown string operation: %s", def.Name)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(&object.String{Value: result})
+}
+
+// Operators of the opcodes which IntegerOperation implements
+var integerOperators = map[code.Opcode]string{
+	code.OpAdd:        "+",
+	code.OpSub:        "-",
+	code.OpMul:        "*",
+	code.OpDiv:        "/",
+	code.OpMod:        "%",
+	code.OpBitAnd:     "&",
+	code.OpBitOr:      "|",
+	code.OpBitXor:     "^",
+	code.OpShiftLeft:  "<<",
+	code.OpShiftRight: ">>",
+}
+
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object, right object.Object) error {
+	operator, ok := integerOperators[op]
+	if !ok {
+		def, _ := code.Lookup(op)
+		return fmt.Errorf("unknown integer operator: %s", def.Name)
+	}
+
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		if result, ok := object.Int64Operation(operator, l.Value, r.Value); ok {
+			return vm.push(&object.Integer{Value: result})
+		}
+	}
+
+	result, err := object.IntegerOperation(operator, left, right)
+	if err != nil {
+		return err
+	}
+	return vm.pushInteger(result)
+}
+
+// Pushes the result of integer arithmetic, which is an error if it overflows and the VM does
+// not promote to big integers
+func (vm *VM) pushInteger(result object.Object) error {
+	if _, ok := result.(*object.BigInteger); ok && vm.config.Overflow == OverflowError {
+		return fmt.Errorf("integer overflow")
+	}
+	return vm.push(result)
 }
 
 func (vm *VM) push(obj object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("Stack overflow")
+	if vm.sp >= len(vm.stack) {
+		err := vm.ensureStack(vm.sp + 1)
+		if err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = obj
@@ -1,14 +1,20 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ShivankSharma070/go-compiler/ast"
+	"github.com/ShivankSharma070/go-compiler/code"
 	"github.com/ShivankSharma070/go-compiler/compiler"
+	"github.com/ShivankSharma070/go-compiler/evaluator"
 	"github.com/ShivankSharma070/go-compiler/lexer"
 	"github.com/ShivankSharma070/go-compiler/object"
 	"github.com/ShivankSharma070/go-compiler/parser"
+	"github.com/ShivankSharma070/go-compiler/token"
 )
 
 type vmTestCase struct {
@@ -34,6 +40,107 @@ func TestIntegerArigthmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 + 7 % 3 * 4", 6},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"1 << 64", bigInt("18446744073709551616")},
+		{"~5", -6},
+		{"1 + 2 << 3 | 4 & 5", 21},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"-9223372036854775808", -9223372036854775808},
+		{"123456789012345678901234567890", bigInt("123456789012345678901234567890")},
+		{"123456789012345678901234567890 * 10 / 10 - 123456789012345678901234567890", 0},
+		{"(1 << 100) >> 99", 2},
+		{"~(1 << 64)", bigInt("-18446744073709551617")},
+		{"(1 << 64) % 10", 6},
+		{"(1 << 64) > 9223372036854775807", true},
+		{"-(1 << 64) < -9223372036854775807", true},
+		{"(1 << 64) == 18446744073709551616", true},
+		{"(1 << 64) != 1", true},
+		{"(1 << 64) * 0.5", 9223372036854775808.0},
+		{"float(1 << 64) == 18446744073709551616.0", true},
+		{"{1 << 64: 1}[18446744073709551616]", 1},
+		{"[1, 2][1 << 64]", Null},
+		{`
+		let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };
+		fact(25)`, bigInt("15511210043330985984000000")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCheckedIntegerOverflow(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"9223372036854775807 + 1", "integer overflow"},
+		{"let x = -9223372036854775807 - 1; -x", "integer overflow"},
+		{"let x = 3037000500; x * x", "integer overflow"},
+		{"1 << 63", "integer overflow"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(21)", "integer overflow"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(20)", ""},
+		{"let x = 9223372036854775807; x - 1 + 1", ""},
+		{"9223372036854775808 > 1", ""},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := NewWithConfig(comp.Bytecode(), Config{Overflow: OverflowError})
+		err = vm.Run()
+		if tt.expectedErr == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected vm error: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%q: expected error %q, got none", tt.input, tt.expectedErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expectedErr) {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expectedErr, err.Error())
+		}
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1", 2.5},
+		{"1 - 0.5", 0.5},
+		{"2 * 1.25", 2.5},
+		{"10 / 4.0", 2.5},
+		{"10 / 4", 2},
+		{"7.5 % 2", 1.5},
+		{"let x = 0.5; -x * 3", -1.5},
+		{"1 == 1.0", true},
+		{"1 < 1.5", true},
+		{"2.5 >= 3", false},
+		{"2.5 <= 2.5", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"1 / 0.0 > 1e308", true},
+		{"let nan = 0.0 / 0.0; nan == nan", false},
+		{"{1.5: 1, 2: 2}[1.5]", 1},
 	}
 
 	runVmTests(t, tests)
@@ -76,6 +183,50 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!true", true},
 		{"!!false", false},
 		{"!!5", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{`"abc" < "abd"`, true},
+		{`"b" > "abc"`, true},
+		{`"ab" < "a"`, false},
+		{`"a" <= "a" + ""`, true},
+		{`"" >= "a"`, false},
+		{"6 & 4 == 4", true},
+		{`"abc" == "ab" + "c"`, true},
+		{`let s = "x"; s + s != "xx"`, false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, 2] != [1, 2, 3]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"if (false) { 1 } == if (false) { 2 }", true},
+		{"if (false) { 1 } == false", false},
+		{`1 == "1"`, false},
+		{`[1] != {}`, true},
+		{"[1, 2.0] == [1.0, 2]", true},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"if (false) { 1 } || false", false},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"let x = 0; true || (x = 1); false && (x = 2); x", 0},
+		{"let x = 0; false || (x = 1); true && (x = x + 1); x", 2},
+		{"let f = fn(n) { n > 0 && f(n - 1) }; f(3)", false},
+		{"let r = []; for (x in [1, 2, 3]) { r = push(r, x > 1 || if (true) { continue; }) }; r", []any{true, true}},
 	}
 
 	runVmTests(t, tests)
@@ -140,16 +291,101 @@ func TestIndexExpression(t *testing.T) {
 		{"[[1, 1, 1]][0][0]", 1},
 		{"[][0]", Null},
 		{"[1, 2, 3][99]", Null},
-		{"[1][-1]", Null},
+		{"[1][-1]", 1},
+		{"[1, 2, 3][-2]", 2},
+		{"[1][-2]", Null},
+		{"[1][1 << 64]", Null},
+		{`"abc"[1]`, "b"},
+		{`"abc"[-1]`, "c"},
+		{`"abc"[3]`, Null},
+		{`"日本語"[1]`, "本"},
+		{`"日本語"[-1]`, "語"},
+		{`"日本語"[3]`, Null},
 		{"{1: 1, 2: 2}[1]", 1},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		// Keys which are equal with == find the same pair
+		{`{1: "a"}[1.0]`, "a"},
+		{`{2.0: "a"}[2]`, "a"},
+		{`{1: "a"}[1.5]`, Null},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestSliceExpression(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3, 4][1:3]", []int{2, 3}},
+		{"[1, 2, 3][1:]", []int{2, 3}},
+		{"[1, 2, 3][:2]", []int{1, 2}},
+		{"[1, 2, 3][:]", []int{1, 2, 3}},
+		{"[1, 2, 3][-2:]", []int{2, 3}},
+		{"[1, 2, 3][:-1]", []int{1, 2}},
+		{"[1, 2, 3][-10:10]", []int{1, 2, 3}},
+		{"[1, 2, 3][2:1]", []int{}},
+		{"[1, 2, 3][0:1 << 64]", []int{1, 2, 3}},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[-3:]`, "llo"},
+		{`"hello"[4:2]`, ""},
+		{`"héllo, 世界"[1:5]`, "éllo"},
+		{`"héllo, 世界"[-2:]`, "世界"},
+		// Invalid UTF-8 counts one character per byte
+		{"\"\xff\xfeé\"[1:]", "\xfeé"},
+		// Slices are copies
+		{"let a = [1, 2]; let b = a[:]; b[0] = 9; a", []int{1, 2}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2, 3]; a[0] = 9; a", []int{9, 2, 3}},
+		{"let a = [1, 2, 3]; a[-1] = 9; a", []int{1, 2, 9}},
+		{"let a = [1, 2]; a[1] = 5", 5},
+		{"let a = [[1], [2]]; a[1][0] = 3; a", []any{[]int{1}, []int{3}}},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] = 3; [h["a"], h["b"]]`, []int{3, 2}},
+		// Arrays are shared, not copied, by variables and arguments
+		{"let a = [1]; let b = a; b[0] = 2; a[0]", 2},
+		{"let set = fn(arr) { arr[0] = 7 }; let a = [0]; set(a); a", []int{7}},
+		{"let a = [0, 0]; for (i, x in a) { a[i] = i + 1 }; a", []int{1, 2}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"let a = [1]; a[1] = 2", "index out of range: 1 with length 1"},
+		{`let a = [1]; a["x"] = 2`, "array index must be INTEGER, got STRING"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{"let h = {}; h[[1]] = 2", "unusable as hash key: ARRAY"},
+		{`[1, 2]["a":]`, "slice index must be INTEGER, got STRING"},
+		{"5[1:2]", "slice operator not supported: INTEGER"},
+		{`"abc"["a"]`, "string index must be INTEGER, got STRING"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil {
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+		}
+		if err == nil {
+			t.Errorf("%q: expected error %q, got none", tt.input, tt.expectedErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expectedErr) {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expectedErr, err.Error())
+		}
+	}
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{`fn(){5+10;}()`, 15},
@@ -200,6 +436,27 @@ func TestFunctionWithReturnStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{`return 5; 6`, 5},
+		{`let x = 1; if (x > 0) { return x + 1; } x`, 2},
+		{`for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } }; 0`, 20},
+		{`let f = fn(x) { x * 2 }; return f(4); 0`, 8},
+	}
+
+	runVmTests(t, tests)
+
+	// The compiler only emits OpReturn inside functions, but bytecode files can have it anywhere
+	bytecode := &compiler.Bytecode{
+		Instructions: concatInstructions(code.Make(code.OpReturn), code.Make(code.OpTrue)),
+	}
+	vm := New(bytecode)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, Null, vm.LastPoppedStackElem())
+}
+
 func TestFunctionWithoutReturnStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -403,42 +660,27 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: "argument to `len` not supported, got INTEGER",
-			},
-		},
-		{`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
+		{`len("héllo, 世界")`, 9},
+		{`bytelen("héllo, 世界")`, 14},
+		{`bytelen("")`, 0},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{`first(1)`,
-			&object.Error{
-				Message: "argument to `first` must be ARRAY, got INTEGER",
-			},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{`last(1)`,
-			&object.Error{
-				Message: "argument to `last` must be ARRAY, got INTEGER",
-			},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`,
-			&object.Error{
-				Message: "argument to `push` must be ARRAY, got INTEGER",
-			},
-		},
+		{`int(3.99)`, 3},
+		{`int(-3.99)`, -3},
+		{`int(" 42 ")`, 42},
+		{`int(1e20)`, bigInt("100000000000000000000")},
+		{`int("-123456789012345678901234567890")`, bigInt("-123456789012345678901234567890")},
+		{`float(100000000000000000000)`, 1e20},
+		{`float(3) / 2`, 1.5},
+		{`float("2.25")`, 2.25},
 	}
 
 	runVmTests(t, tests)
@@ -649,11 +891,38 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 		if err != nil {
 			t.Errorf("testIntegerLiteral error: %s", err)
 		}
+	case *big.Int:
+		b, ok := actual.(*object.BigInteger)
+		if !ok {
+			t.Errorf("object is not BigInteger: %T (%+v)", actual, actual)
+			return
+		}
+		if b.Value.Cmp(expected) != 0 {
+			t.Errorf("wrong big integer. want=%s, got=%s", expected, b.Value)
+		}
+	case float64:
+		f, ok := actual.(*object.Float)
+		if !ok {
+			t.Errorf("object is not Float: %T (%+v)", actual, actual)
+			return
+		}
+		if f.Value != expected {
+			t.Errorf("wrong float. want=%g, got=%g", expected, f.Value)
+		}
 	case bool:
 		err := testBooleanLiteral(actual, expected)
 		if err != nil {
 			t.Errorf("testBooleanLiteral error: %s", err)
 		}
+	case string:
+		str, ok := actual.(*object.String)
+		if !ok {
+			t.Errorf("object is not String: %T (%+v)", actual, actual)
+			return
+		}
+		if str.Value != expected {
+			t.Errorf("wrong string. want=%q, got=%q", expected, str.Value)
+		}
 	case *object.Error:
 		errObj, ok := actual.(*object.Error)
 		if !ok {
@@ -684,6 +953,20 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 				t.Errorf("testIntegerLiteral error: %s", err)
 			}
 		}
+	case []any:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object is not array: %T (%+v)", actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements: want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElement := range expected {
+			testExpectedObject(t, expectedElement, array.Elements[i])
+		}
 
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
@@ -691,13 +974,17 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 			t.Errorf("object is not Hash. got=%T", actual)
 		}
 
-		if len(hash.Pair) != len(expected) {
-			t.Errorf("hash has wrong number of pairs, want=%d, got=%d", len(expected), len(hash.Pair))
+		if hash.Pairs.Len() != len(expected) {
+			t.Errorf("hash has wrong number of pairs, want=%d, got=%d", len(expected), hash.Pairs.Len())
 			return
 		}
 
+		pairs := map[object.HashKey]object.HashPair{}
+		for _, pair := range hash.Pairs.Pairs() {
+			pairs[pair.Key.(object.Hashable).HashKey()] = pair
+		}
 		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pair[expectedKey]
+			pair, ok := pairs[expectedKey]
 			if !ok {
 				t.Errorf("no pair for given key in pairs. %d", expectedKey.Value)
 			}
@@ -723,6 +1010,14 @@ func testBooleanLiteral(actual object.Object, value bool) error {
 	return nil
 }
 
+func bigInt(s string) *big.Int {
+	value, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("malformed big integer " + s)
+	}
+	return value
+}
+
 func testIntegerLiteral(obj object.Object, value int64) error {
 	actual, ok := obj.(*object.Integer)
 	if !ok {
@@ -735,3 +1030,953 @@ func testIntegerLiteral(obj object.Object, value int64) error {
 
 	return nil
 }
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(a) { a };
+let outer = fn() {
+  inner(1, 2)
+};
+let run = fn() { outer() + 0 };
+run();`
+	// outer() is not in tail position, so run keeps its frame
+
+	l := lexer.NewWithFilename("trace.mk", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError, got %T (%+v)", err, err)
+	}
+
+	if rtErr.Error() != "wrong number of arguments: want=1, got=2" {
+		t.Errorf("wrong error message, got %q", rtErr.Error())
+	}
+
+	expected := []string{
+		"outer (trace.mk:3:3)",
+		"run (trace.mk:5:18)",
+		"<main> (trace.mk:6:1)",
+	}
+	if len(rtErr.Frames) != len(expected) {
+		t.Fatalf("wrong number of frames. want=%d, got=%d (%+v)", len(expected), len(rtErr.Frames), rtErr.Frames)
+	}
+	for i, frame := range rtErr.Frames {
+		if frame.String() != expected[i] {
+			t.Errorf("wrong frame %d. want=%q, got=%q", i, expected[i], frame.String())
+		}
+	}
+
+	trace := "runtime error: wrong number of arguments: want=1, got=2\n" +
+		"    at outer (trace.mk:3:3)\n" +
+		"    at run (trace.mk:5:18)\n" +
+		"    at <main> (trace.mk:6:1)\n"
+	if rtErr.StackTrace() != trace {
+		t.Errorf("wrong stack trace.\nwant=%q\ngot= %q", trace, rtErr.StackTrace())
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input    string
+		config   Config
+		expected string
+		frames   int
+	}{
+		{
+			input:    `let f = fn(x) { 1 + f(x + 1) }; f(0);`,
+			config:   DefaultConfig(),
+			expected: "stack overflow: stack size of 2048 exceeded",
+		},
+		{
+			input:    `let f = fn(x) { 1 + f(x + 1) }; f(0);`,
+			config:   Config{StackSize: 100000},
+			expected: "stack overflow: maximum call depth of 1024 frames exceeded",
+			frames:   1024,
+		},
+		{
+			input:    `let f = fn(x) { 1 + f(x + 1) }; f(0);`,
+			config:   Config{MaxFrames: 10},
+			expected: "stack overflow: maximum call depth of 10 frames exceeded",
+			frames:   10,
+		},
+		{
+			input:    `let f = fn(x) { 1 + f(x + 1) }; f(0);`,
+			config:   Config{StackSize: 100, MaxFrames: 1000},
+			expected: "stack overflow: stack size of 100 exceeded",
+		},
+		{
+			input:    `let f = fn(x) { if (x == 0) { 0 } else { 1 + f(x - 1) } }; f(1500);`,
+			config:   Config{StackSize: 10000, MaxFrames: 2000},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := NewWithConfig(comp.Bytecode(), tt.config)
+		err = vm.Run()
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected vm error: %s", err)
+			}
+			continue
+		}
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError, got %T (%+v)", err, err)
+		}
+		if rtErr.Message != tt.expected {
+			t.Errorf("wrong error message. want=%q, got=%q", tt.expected, rtErr.Message)
+		}
+		if tt.frames != 0 && len(rtErr.Frames) != tt.frames {
+			t.Errorf("wrong number of frames. want=%d, got=%d", tt.frames, len(rtErr.Frames))
+		}
+	}
+}
+
+func TestStackTraceCollapsesRecursion(t *testing.T) {
+	err := &RuntimeError{
+		Message: "stack overflow",
+		Frames: []StackFrame{
+			{Function: "f", Pos: token.Position{Line: 1, Column: 17}},
+			{Function: "f", Pos: token.Position{Line: 1, Column: 17}},
+			{Function: "f", Pos: token.Position{Line: 1, Column: 17}},
+			{Function: "<main>", Pos: token.Position{Line: 1, Column: 29}},
+		},
+	}
+
+	expected := "runtime error: stack overflow\n" +
+		"    at f (1:17)\n" +
+		"    ... repeated 2 more times\n" +
+		"    at <main> (1:29)\n"
+	if err.StackTrace() != expected {
+		t.Errorf("wrong stack trace.\nwant=%q\ngot= %q", expected, err.StackTrace())
+	}
+}
+
+func TestRuntimeFaults(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		pos      string
+	}{
+		{"10 / 0", "division by zero", "1:4"},
+		{"let f = fn(x) {\n  10 / x\n};\nf(0)", "division by zero", "2:6"},
+		{`[1, 2, 3]["a"]`, "array index must be INTEGER, got STRING", "1:10"},
+		{"5(1)", "calling non-function: INTEGER", "1:1"},
+		{`"a"(1)`, "calling non-function: STRING", "1:1"},
+		{"let x = 0; 10 % x", "division by zero", "1:15"},
+		{"1 << -1", "negative shift count: -1", "1:3"},
+		{`~"a"`, "unsupported type for bitwise not: STRING", "1:1"},
+		{"1.5 & 1", "unsupported types for binary operation: FLOAT INTEGER", "1:5"},
+		{"true >= false", "unknown operator: BOOLEAN >= BOOLEAN", "1:6"},
+		{"[1] > [2]", "unknown operator: ARRAY > ARRAY", "1:5"},
+		{"let f = fn() {}; 1 < f", "unknown operator: CLOSURE > INTEGER", "1:20"},
+		{"len(1)", "argument to `len` not supported, got INTEGER", "1:1"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1", "1:1"},
+		{"bytelen([1])", "argument to `bytelen` must be STRING, got ARRAY", "1:1"},
+		{"let f = fn(x) {\n  first(x)\n};\nf(1)", "argument to `first` must be ARRAY, got INTEGER", "2:3"},
+		{"last(1)", "argument to `last` must be ARRAY, got INTEGER", "1:1"},
+		{"push(1, 1)", "argument to `push` must be ARRAY, got INTEGER", "1:1"},
+		{`int("4.5")`, `cannot convert "4.5" to INTEGER`, "1:1"},
+		{"int(1.0 / 0.0)", "cannot convert +Inf to INTEGER", "1:1"},
+		{"float([])", "argument to `float` not supported, got ARRAY", "1:1"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("error is not *RuntimeError for %q, got %T (%+v)", tt.input, err, err)
+			continue
+		}
+		if rtErr.Message != tt.expected {
+			t.Errorf("wrong error message. want=%q, got=%q", tt.expected, rtErr.Message)
+		}
+		if rtErr.Pos().String() != tt.pos {
+			t.Errorf("wrong error position for %q. want=%s, got=%s", tt.input, tt.pos, rtErr.Pos())
+		}
+	}
+}
+
+func TestRunRecoversFromPanics(t *testing.T) {
+	// Builtin index past the end of object.Builtins makes the VM itself panic
+	bytecode := &compiler.Bytecode{
+		Instructions: concatInstructions(
+			code.Make(code.OpGetBuiltin, 250),
+			code.Make(code.OpPop),
+		),
+	}
+
+	vm := New(bytecode)
+	err := vm.Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError, got %T (%+v)", err, err)
+	}
+	if !strings.HasPrefix(rtErr.Message, "internal error: ") {
+		t.Errorf("wrong error message, got %q", rtErr.Message)
+	}
+	if len(rtErr.Frames) != 1 || rtErr.Frames[0].Function != MainFunctionName {
+		t.Errorf("wrong frames, got %+v", rtErr.Frames)
+	}
+}
+
+func concatInstructions(instructions ...code.Instructions) code.Instructions {
+	var out code.Instructions
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func TestRunDeserializedBytecode(t *testing.T) {
+	input := `
+	let fibonacci = fn(x) {
+		if (x < 2) { return x; }
+		fibonacci(x - 1) + fibonacci(x - 2)
+	};
+	let names = {"fib": fibonacci(15)};
+	names["fib"]
+	`
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = compiler.WriteBytecode(&buf, comp.Bytecode())
+	if err != nil {
+		t.Fatalf("WriteBytecode error: %s", err)
+	}
+	bytecode, err := compiler.ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode error: %s", err)
+	}
+
+	vm := New(bytecode)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 610, vm.LastPoppedStackElem())
+}
+
+func TestVerifyCompiledPrograms(t *testing.T) {
+	inputs := []string{
+		`1 + 2; if (true) { 10 } else { 20 }; if (false) { 10 };`,
+		`let newAdder = fn(a, b) { fn(c) { a + b + c } }; newAdder(1, 2)(3);`,
+		`let countDown = fn(x) { if (x == 0) { return 0; } countDown(x - 1) }; countDown(5);`,
+		`let map = fn(arr, f) { let iter = fn(arr, acc) { if (len(arr) == 0) { acc } else { iter(rest(arr), push(acc, f(first(arr)))) } }; iter(arr, []) }; map([1, 2], fn(x) { x * 2 });`,
+		`{"a": [1, 2][0], "b": fn() {}}["a"]; puts("x"); return 5;`,
+		`let x = 3; if (x > 2) { return x; } for (y in [1]) { return y; } x`,
+		`let f = fn(h) { let n = 0; for (k, v in h) { while (v > 0) { v = v - 1; if (v == 2) { continue; } n = n + [k, if (v == 5) { break; } else { v }][1]; } } n }; f({1: 3, 2: 9});`,
+	}
+
+	for _, input := range inputs {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Errorf("compiled bytecode for %q failed verification: %s", input, err)
+		}
+
+		// Bytecode which passes must also run
+		err = New(comp.Bytecode()).Run()
+		if err != nil {
+			t.Errorf("verified bytecode for %q failed to run: %s", input, err)
+		}
+	}
+}
+
+func TestVerifyRejectsMalformedBytecode(t *testing.T) {
+	function := func(numLocals, numParams int, instructions ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{
+			Name:          "f",
+			Instructions:  concatInstructions(instructions...),
+			NumLocals:     numLocals,
+			NumParameters: numParams,
+		}
+	}
+
+	tests := []struct {
+		bytecode    *compiler.Bytecode
+		expectedErr string
+	}{
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpConstant, 1), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode in <main> at offset 0000: constant 1 out of range, pool has 1",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode in <main> at offset 0000: OpClosure refers to constant 0 of type INTEGER, not a compiled function",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpPop)),
+			},
+			"invalid bytecode in <main> at offset 0000: stack underflow, OpPop needs 1 values, stack has 0",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpGetBuiltin, 200), code.Make(code.OpPop)),
+			},
+			fmt.Sprintf("invalid bytecode in <main> at offset 0000: builtin 200 out of range, there are %d", len(object.Builtins)),
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpGetGlobal, GlobalSize), code.Make(code.OpPop)),
+			},
+			"invalid bytecode in <main> at offset 0000: global 65536 out of range, there are 65536",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: code.Instructions{byte(code.OpWide), byte(code.OpPop)},
+			},
+			"invalid bytecode in <main> at offset 0000: OpWide can not prefix OpPop, it has no operands",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(
+					code.Make(code.OpNull),
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 9),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode in <main> at offset 0009: inconsistent stack depth, 1 on one path and 3 on another",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{
+					function(1, 0, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue)),
+				},
+			},
+			"invalid bytecode in f (constant 0) at offset 0000: local 1 out of range, function has 1",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{
+					function(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+				},
+			},
+			"invalid bytecode in f (constant 0) at offset 0000: free variable 0 out of range, function is closed over 0",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{
+					function(0, 0, code.Make(code.OpNull), code.Make(code.OpPop)),
+				},
+			},
+			"invalid bytecode in f (constant 0) at offset 0002: function can end without returning a value",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{
+					function(1, 2, code.Make(code.OpReturn)),
+				},
+			},
+			"invalid bytecode in f (constant 0) at offset 0000: 2 parameters do not fit in 1 locals",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpJump, 1)),
+			},
+			"invalid bytecode in <main> at offset 0000: jump target 1 is not on an instruction boundary",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpArray, 0), code.Make(code.OpIter), code.Make(code.OpIterNext, 8, 3), code.Make(code.OpPop)),
+			},
+			"invalid bytecode in <main> at offset 0004: OpIterNext takes 1 or 2 loop variables, got 3",
+		},
+		{
+			// The body of the loop does not consume the loop variable
+			&compiler.Bytecode{
+				Instructions: concatInstructions(code.Make(code.OpArray, 0), code.Make(code.OpIter), code.Make(code.OpIterNext, 11, 1), code.Make(code.OpJump, 4), code.Make(code.OpPop)),
+			},
+			"invalid bytecode in <main> at offset 0004: inconsistent stack depth, 1 on one path and 2 on another",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.bytecode)
+		if err == nil {
+			t.Errorf("expected error %q, got none", tt.expectedErr)
+			continue
+		}
+		if err.Error() != tt.expectedErr {
+			t.Errorf("wrong error.\nwant=%q\ngot =%q", tt.expectedErr, err.Error())
+		}
+	}
+}
+
+func TestOptimizedProgramsMatchEvaluator(t *testing.T) {
+	inputs := []string{
+		`1 + 2 * 3 - 10 / 5`,
+		`-(2 - 5) * -3`,
+		`9223372036854775807 + 1`,
+		`!true == !!false`,
+		`!5`,
+		`!"monkey"`,
+		`1 < 2 == true`,
+		`(5 > 3) != (2 > 4)`,
+		`"mon" + "key" + "!"`,
+		`len("abc" + "de")`,
+		`if (1 > 2) { 10 } else { 20 }`,
+		`if (1 < 2) { 10 } else { 20 }`,
+		`if (false) { 10 }`,
+		`if ("yes") { 1 + 1 }`,
+		`let x = 4; if (true) { x * (2 + 3) }`,
+		`let f = fn(a) { if (true) { return a + 2 * 3; } 99 }; f(1)`,
+		`let f = fn(a) { if (!true) { a } else { a - (10 - 20) } }; f(1)`,
+		`[1 + 1, 2 * 2][3 - 2]`,
+		`{"a" + "b": 10 / 2}["ab"]`,
+		`let x = 7; 1 / (x - 7)`,
+		`1 / (3 - 3)`,
+		`1 + (true == true)`,
+		`if (false) { 1 }; if (1 > 2) { 3 }; 4`,
+		`let f = fn(x) { if (x) { 1 }; if (false) { 2 }; 3 }; f(true) + f(false)`,
+		`let x = 0; if (x) { 1 }`,
+		`let x = 1; x = x + 1; x = x * 10`,
+		`let f = fn() { let n = 1; let g = fn() { n = n + 1 }; g(); g() + n }; f()`,
+		`let f = fn(n) { 1 }; let g = f; f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; g(5) + f(5)`,
+		`let n = 0; while (n < 10) { n = n + 3 }; n`,
+		`let s = 0; for (i, x in [5, 6, 7]) { if (i == 1) { continue; } s = s + x }; s`,
+		`let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }) }; fs[0]() + fs[1]() * 10`,
+		`let f = fn() { let r = []; for (x in [1, 2, 3]) { r = push(r, 1 + if (x == 2) { break; } else { x }) } r }; f()`,
+		`let f = fn() { for (x in [1, 2]) { return x * 10 } }; f()`,
+		`for (x in 1) { x }`,
+		`let x = 1; (x > 0 && x < 2) || x == 5`,
+		`false && 1 / 0`,
+		`true && 1 / 0`,
+		`let f = fn(a) { a || !a && false }; [f(true), f(false), f(0)]`,
+		`[7 % 3, -7 % 3, 7 % -3, 1 % 0]`,
+		`[5 & 3, 5 | 3, 5 ^ 3, ~5, 1 << 62, -1 >> 63, 1 << 64]`,
+		`1 >> -2`,
+		`let x = 3; [x <= 3, x >= 4, 2 <= x, "x" + "y" > "xx", "a" <= "b"]`,
+		`"a" % "b"`,
+		`[1.5 + 1, 3 / 2.0, -0.5 * 4, 1 == 1.0, 2.5 > 2, 7.5 % 2, 1 / 0.0, -(2.5)]`,
+		`let avg = fn(xs) { let s = 0; for (x in xs) { s = s + x }; s / float(len(xs)) }; avg([1, 2, 4])`,
+		`[int(2.9), float(2), !0.0, if (0.0) { 1 }]`,
+		`1.5 | 1`,
+		`[9223372036854775807 * 3, -9223372036854775807 - 2, 170141183460469231731687303715884105728 / 3]`,
+		`let x = 1 << 70; [x, -x, ~x, x >> 69, x % 1000, x == 1 << 70, x > 1, {x: 1}[1 << 70]]`,
+		`let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(30)`,
+		`1 << 1048576`,
+		`{"b": 1, "a": 2, 3: 4, true: 5, 1.5: 6}`,
+		`let a = [1, {"k": [2, "s"]}]; [a == [1, {"k": [2, "s"]}], a != a, "s" == "s", [] == {}, 1 == true]`,
+		`let nan = 0.0 / 0.0; [nan == nan, [nan] == [nan], [1] == [1.0], 2 != 2.0]`,
+		`if (false) { 1 } == if (false) { 2 }`,
+		`let log = []; let f = fn(x) { log = push(log, x); x }; let h = {f("b"): f(1), f("a"): f(2)}; [log, h]`,
+		`let h = {"x": 1, "y": 2, "x": 3}; let r = []; for (k, v in h) { r = push(r, [k, v]) }; r`,
+		`[int(1e30), float(1 << 70), -9223372036854775808, 9223372036854775808 + 0.5]`,
+		`let a = [1, 2, 3, 4]; [a[-1], a[1:3], a[:-2], a[3:1], "hello"[1], "hello"[-4:-1], [1, 2][:1 + 1]]`,
+		`let a = [0, [1]]; a[0] = 5; a[1][0] = a[0] + 1; let h = {}; h["k"] = a; h["k"][-1] = 3; [a, h]`,
+		`let a = [1]; a[0] = a; a`,
+		`let a = [1]; a[2] = 3`,
+		`"abc"[0] = "x"`,
+		`let h = {1: "a", 2.5: "b"}; h[1.0] = "c"; h[0.0] = "d"; h[-0.0] = "e"; [h, h == {1.0: "c", 2.5: "b", 0: "e"}, {1: 1, 1.0: 2}]`,
+		`let x = 4; while (x > 0) { if (x == 2) { return x; } x = x - 1 }; 9`,
+		`let f = fn(x) { len(x) }; [f("ab"), f(1)]`,
+		`{2: "b", 1 + 1: "a"}`,
+		`let log = []; let f = fn(x) { log = push(log, x); x }; [{f(3): 1, 1 + f(1): 2, f(2): 3}, log]`,
+		`if (false) { let x = 1; }; let g = fn() { x }; 2`,
+		`if (false) { if (true) { let x = 1; } } else { 3 }; let g = fn() { x }; 4`,
+		`let f = fn() { if (true) { 1 } else { let y = 2; }; let g = fn() { y }; 3 }; f()`,
+		`if (false) { fn() { let y = 1; y } }; 5`,
+		`let größe = "naïve café"; [len(größe), bytelen(größe), größe[2], größe[-4:], größe[:2] + größe[2:] == größe]`,
+	}
+
+	for _, input := range inputs {
+		expected := evaluator.Eval(parse(input), object.NewEnvironment())
+		_, expectError := expected.(*object.Error)
+
+		// Error messages differ between the engines, but optimizing must not change them
+		results := []string{}
+		for _, level := range []compiler.OptimizationLevel{compiler.OptimizeNone, compiler.OptimizeFold, compiler.OptimizePeephole} {
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			err := comp.Compile(parse(input))
+			if err != nil {
+				t.Fatalf("%q: compiler error: %s", input, err)
+			}
+
+			err = Verify(comp.Bytecode())
+			if err != nil {
+				t.Errorf("%q at level %d: %s", input, level, err)
+			}
+
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			switch {
+			case expectError && err == nil:
+				t.Errorf("%q at level %d: want error %q, got none", input, level, expected.Inspect())
+			case expectError:
+				results = append(results, "error: "+err.Error())
+			case err != nil:
+				t.Errorf("%q at level %d: vm error: %s", input, level, err)
+			default:
+				actual := vm.LastPoppedStackElem()
+				if actual.Inspect() != expected.Inspect() {
+					t.Errorf("%q at level %d: want %s, got %s", input, level, expected.Inspect(), actual.Inspect())
+				}
+				results = append(results, actual.Inspect())
+			}
+		}
+
+		for _, result := range results {
+			if result != results[0] {
+				t.Errorf("%q: optimized result %q differs from unoptimized %q", input, result, results[0])
+			}
+		}
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 5; a = 6; a", 6},
+		{"let a = 5; a = a + 1", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let a = 1; let f = fn() { a = a + 1; }; f(); f(); a", 3},
+		{"let f = fn(a) { a = a * 2; a }; f(21)", 42},
+		{"let f = fn() { let a = 1; a = a + 1; a }; f()", 2},
+		{
+			`let counter = fn() { let n = 0; fn() { n = n + 1 } };
+			let c = counter(); let d = counter();
+			c(); c(); d(); c() * 10 + d()`,
+			32,
+		},
+		{
+			// Closures and the function defining them share the variable
+			`let f = fn() { let n = 1; let get = fn() { n }; n = 5; get() }; f()`,
+			5,
+		},
+		{
+			// A captured parameter, assigned two closures deep
+			`let f = fn(n) { let add = fn(k) { let inc = fn() { n = n + k }; inc() }; add(2); add(3); n }; f(10)`,
+			15,
+		},
+		{
+			// A local function calling itself through a variable which is reassigned
+			`let f = fn() {
+				let g = fn(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } };
+				let first = g;
+				g = fn(n) { 100 };
+				first(3)
+			};
+			f()`,
+			101,
+		},
+		{
+			// Tail calls restart the function, parameters go into fresh cells
+			`let loop = fn(n, acc) {
+				let get = fn() { acc };
+				acc = acc + n;
+				if (n == 0) { get() } else { loop(n - 1, acc) }
+			};
+			loop(10000, 0)`,
+			50005000,
+		},
+		{
+			"let f = fn() { " + repeatStatements(300, "let %[1]s = %[2]d; ") + "let g = fn() { vnl = vnl + 1 }; g(); vnl }; f()",
+			300,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"x = 1", "undefined variable: x"},
+		{"len = 1", "cannot assign to builtin len"},
+		{"let f = fn() { y = 1 }; let y = 2;", "undefined variable: y"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("%q: expected error %q, got none", tt.input, tt.expectedErr)
+			continue
+		}
+		if err.Error() != tt.expectedErr {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expectedErr, err.Error())
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 5) { i = i + 1 }; i", 5},
+		{"let i = 0; while (true) { i = i + 1; if (i == 3) { break; } }; i", 3},
+		{"let i = 0; let s = 0; while (i < 5) { i = i + 1; if (i == 2) { continue; } s = s + i }; s", 13},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x }; s", 6},
+		{"let s = 0; for (i, x in [10, 20]) { s = s + i * x }; s", 20},
+		{`let s = ""; for (k in {"b": 1, "a": 2}) { s = s + k }; s`, "ab"},
+		{`let s = 0; for (k, v in {"b": 1, "a": 2}) { s = s * 10 + v }; s`, 21},
+		{"let s = 0; for (x in []) { s = 1 }; s", 0},
+		// break and continue apply to the innermost loop
+		{"let s = 0; for (x in [1, 2, 3]) { for (y in [1, 2, 3]) { if (y > x) { break; } s = s + 1 } }; s", 6},
+		{"let s = 0; for (x in [1, 2, 3]) { let i = 0; while (i < x) { i = i + 1; if (i == 2) { continue; } s = s + 1 } }; s", 4},
+		// Values pushed inside of the loop are popped before jumping out
+		{"let r = []; for (x in [1, 2, 3]) { r = push(r, x * 10 + [x, if (x == 2) { continue; } else { x }][1]) }; r", []int{11, 33}},
+		{"let f = fn() { let n = 0; while (true) { n = n + 1 + if (n > 5) { break; } else { 1 } } n }; f()", 6},
+		// Loops in functions and closures
+		{"let sum = fn(arr) { let s = 0; for (x in arr) { s = s + x } s }; sum([1, 2, 3]) + sum([4])", 10},
+		{"let find = fn(arr, v) { for (i, x in arr) { if (x == v) { return i; } } -1 }; [find([5, 6], 6), find([5], 6)]", []int{1, -1}},
+		{"let f = fn(n) { fn() { let s = 0; while (n > 0) { s = s + n; n = n - 1 } s } }; f(4)()", 10},
+		// Every iteration has variables of its own
+		{"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }) }; [fs[0](), fs[2]()]", []int{1, 3}},
+		{"let f = fn() { let fs = []; for (x in [1, 2]) { let y = x * 10; fs = push(fs, fn() { y = y + 1 }) } fs }; let fs = f(); [fs[0](), fs[0](), fs[1]()]", []int{11, 12, 21}},
+		{"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x = x + 1 }) }; [fs[0](), fs[0](), fs[1]()]", []int{2, 3, 3}},
+		{"let x = 5; for (x in [1]) { x = 9 }; x", 5},
+		// Loops are statements, a function ending in one returns null
+		{"let f = fn() { while (false) { } }; f()", Null},
+		{"if (true) { let x = 1; }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+// A loop has no value, a program ending in one gives null whatever the loop popped last
+func TestLoopsHaveNoValue(t *testing.T) {
+	inputs := []string{
+		"while (false) { 1 }",
+		"for (x in []) { 1 }",
+		"let i = 0; while (i < 3) { i = i + 1 }",
+		"for (x in [1, 2]) { x }",
+		"while (true) { 5; break; }",
+	}
+
+	for _, input := range inputs {
+		for _, level := range []compiler.OptimizationLevel{compiler.OptimizeNone, compiler.OptimizeFold, compiler.OptimizePeephole} {
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			err := comp.Compile(parse(input))
+			if err != nil {
+				t.Fatalf("%q: compiler error: %s", input, err)
+			}
+
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("%q at level %d: vm error: %s", input, level, err)
+			}
+			if actual := vm.LastPoppedStackElem(); actual != Null {
+				t.Errorf("%q at level %d: want null, got %T (%+v)", input, level, actual, actual)
+			}
+		}
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{`for (c in "abc") { c }`, "cannot iterate over STRING"},
+		{"for (x in [1]) { let y = x; }; y", "undefined variable: y"},
+		{"for (x in [1]) { }; x", "undefined variable: x"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil {
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+		}
+		if err == nil {
+			t.Errorf("%q: expected error %q, got none", tt.input, tt.expectedErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expectedErr) {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expectedErr, err.Error())
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			// Far deeper than MaxFrames
+			`let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(100000, 0)`,
+			5000050000,
+		},
+		{
+			`let build = fn(n, arr) { if (n == 0) { return arr; } build(n - 1, push(arr, n)) };
+			let sum = fn(arr, acc) { if (len(arr) == 0) { acc } else { sum(rest(arr), acc + first(arr)) } };
+			sum(build(3000, []), 0)`,
+			4501500,
+		},
+		{
+			`let odd = fn(n, even) { if (n == 0) { false } else { even(n - 1) } };
+			let even = fn(n) { if (n == 0) { true } else { odd(n - 1, even) } };
+			even(10001)`,
+			false,
+		},
+		{
+			// Closures with different locals and free variables replacing each other
+			`let countdown = fn(step) {
+				let inner = fn(n, acc) { let next = n - step; if (n < 1) { acc } else { inner(next, acc + 1) } };
+				inner
+			};
+			let start = fn(n) { let c = countdown(1); c(n, 0) };
+			start(5000)`,
+			5000,
+		},
+		{`let f = fn(a) { len(a) }; f([1, 2, 3])`, 3},
+		{`let f = fn() { puts() }; f()`, Null},
+	}
+
+	runVmTests(t, tests)
+
+	comp := compiler.New()
+	err := comp.Compile(parse(`let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000);`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithConfig(comp.Bytecode(), Config{StackSize: 16, MaxFrames: 3})
+	err = vm.Run()
+	if err != nil {
+		t.Errorf("tail recursion with tiny limits failed: %s", err)
+	}
+
+	comp = compiler.New()
+	err = comp.Compile(parse(`let g = fn(a) { a }; let f = fn() { g(1, 2) }; f();`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm = New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil || err.Error() != "wrong number of arguments: want=1, got=2" {
+		t.Errorf("wrong error for tail call with bad arguments: %v", err)
+	}
+}
+
+// Monkey source for n statements made by format, which gets a distinct identifier as %[1]s and
+// the index as %[2]d
+func repeatStatements(n int, format string) string {
+	var out strings.Builder
+	for i := range n {
+		name := []byte{'v'}
+		for j := i; ; j /= 26 {
+			name = append(name, byte('a'+j%26))
+			if j < 26 {
+				break
+			}
+		}
+		fmt.Fprintf(&out, format, name, i)
+	}
+	return out.String()
+}
+
+// Every instruction with operands runs the same whether or not it has the OpWide prefix. The
+// programs are run as compiled and again with every such instruction rewritten to its wide
+// form, and between them they have to use every opcode which has operands.
+func TestWideInstructionsMatchNarrow(t *testing.T) {
+	inputs := []string{
+		`let a = 1; let f = fn(x, y) { let z = x + y; [z, a, {z: len("ab")}] }; f(1, 2)`,
+		`let mk = fn() { let n = 0; fn() { n = n + 1; n } }; let c = mk(); c(); c()`,
+		`let f = fn() { let m = 1; let g = fn() { m }; m = 2; g() }; f()`,
+		`let s = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } s = s + x }; let i = 0; while (i < 3) { i = i + 1 }; s + i`,
+		`let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x = x + 1 }) }; [fs[0](), fs[0](), fs[1]()]`,
+		`let r = []; for (x in [1, 2]) { let f = fn() { x }; x = x * 10; r = push(r, f()) }; r`,
+		`let f = fn(n, acc) { if (n == 0) { acc } else { f(n - 1, acc + n) } }; f(100, 0)`,
+	}
+
+	seen := map[code.Opcode]bool{}
+	for _, input := range inputs {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("%q: compiler error: %s", input, err)
+		}
+		bytecode := comp.Bytecode()
+
+		wide := &compiler.Bytecode{Instructions: widen(bytecode.Instructions, seen)}
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				copied := *fn
+				copied.Instructions = widen(fn.Instructions, seen)
+				copied.Lines = nil
+				constant = &copied
+			}
+			wide.Constants = append(wide.Constants, constant)
+		}
+
+		results := []string{}
+		for _, bc := range []*compiler.Bytecode{bytecode, wide} {
+			err := Verify(bc)
+			if err != nil {
+				t.Fatalf("%q: %s", input, err)
+			}
+			vm := New(bc)
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("%q: vm error: %s", input, err)
+			}
+			results = append(results, vm.LastPoppedStackElem().Inspect())
+		}
+		if results[0] != results[1] {
+			t.Errorf("%q: wide instructions give %s, narrow ones %s", input, results[1], results[0])
+		}
+	}
+
+	for op := 0; op < 256; op++ {
+		def, err := code.Lookup(code.Opcode(op))
+		if err != nil || len(def.OperandWidths) == 0 {
+			continue
+		}
+		if !seen[code.Opcode(op)] {
+			t.Errorf("no program runs %s, add one which does", def.Name)
+		}
+	}
+}
+
+// Re-encodes every instruction which has operands with the OpWide prefix, moving jump targets
+// to the new offsets, and records the opcodes it widened
+func widen(ins code.Instructions, seen map[code.Opcode]bool) code.Instructions {
+	type instruction struct {
+		op       code.Opcode
+		operands []int
+	}
+	decoded := []instruction{}
+	newOffsets := map[int]int{}
+	length := 0
+	for offset := 0; offset < len(ins); {
+		op, operands, width, err := code.ReadInstruction(ins, offset)
+		if err != nil {
+			panic(err)
+		}
+		newOffsets[offset] = length
+		decoded = append(decoded, instruction{op, operands})
+		if len(operands) > 0 {
+			length += len(code.MakeWide(op, operands...))
+		} else {
+			length += width
+		}
+		offset += width
+	}
+	newOffsets[len(ins)] = length
+
+	widened := code.Instructions{}
+	for _, ins := range decoded {
+		if len(ins.operands) == 0 {
+			widened = append(widened, code.Make(ins.op)...)
+			continue
+		}
+		if i, ok := code.JumpOperand(ins.op); ok {
+			ins.operands[i] = newOffsets[ins.operands[i]]
+		}
+		seen[ins.op] = true
+		widened = append(widened, code.MakeWide(ins.op, ins.operands...)...)
+	}
+	return widened
+}
+
+func TestWideOperands(t *testing.T) {
+	locals := repeatStatements(300, "let %[1]s = %[2]d + 1; ")
+	params := strings.TrimSuffix(repeatStatements(300, "%[1]s, "), ", ")
+	args := strings.TrimSuffix(repeatStatements(300, "%[2]d + 1, "), ", ")
+	sum := strings.TrimSuffix(repeatStatements(300, "%[1]s + "), " + ")
+
+	tests := []vmTestCase{
+		// Locals and free variables past 255
+		{"let f = fn() { " + locals + sum + " }; f()", 45150},
+		{"let f = fn() { " + locals + "fn() { " + sum + " } }; f()()", 45150},
+		// More than 255 arguments, in a regular and a tail call
+		{"let g = fn(" + params + ") { " + sum + " }; g(" + args + ")", 45150},
+		{"let g = fn(" + params + ") { " + sum + " }; let f = fn() { g(" + args + ") }; f()", 45150},
+		// Constants past 65535 and jumps past offset 65535
+		{repeatStatements(70000, "%[2]d; "), 69999},
+		{"let x = 0; if (x == 0) { " + repeatStatements(20000, "%[2]d; ") + "} else { -1 }", 19999},
+		{"let f = fn(x) { if (x == 0) { " + repeatStatements(20000, "%[2]d; ") + "} else { -1 } }; [f(0), f(1)]", []int{19999, -1}},
+		// Breaks and loop exits past offset 65535
+		{"let s = 0; for (x in [1, 2, 3]) { if (x == 3) { break; } s = s + x; " + repeatStatements(20000, "%[2]d; ") + "}; s", 3},
+		{"let f = fn() { let i = 0; while (i < 3) { i = i + 1; if (i == 2) { continue; } " + repeatStatements(20000, "%[2]d; ") + "} i }; f()", 3},
+	}
+
+	runVmTests(t, tests)
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Errorf("verify failed: %s", err)
+		}
+	}
+}
+
+func TestBuiltinErrorStackTrace(t *testing.T) {
+	input := "let f = fn(x) {\n  len(x) + 1\n};\nf(1) + 1"
+
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError, got %T (%+v)", err, err)
+	}
+
+	trace := "runtime error: argument to `len` not supported, got INTEGER\n" +
+		"    at f (2:3)\n" +
+		"    at <main> (4:1)\n"
+	if rtErr.StackTrace() != trace {
+		t.Errorf("wrong stack trace.\nwant=%q\ngot= %q", trace, rtErr.StackTrace())
+	}
+}